@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Default states of the collectors, used on registration.
+const (
+	defaultEnabled  = true
+	defaultDisabled = false
+)
+
+// Scopes of the collectors, used on registration.
+const (
+	// masterOnly collectors query cluster-wide views and run only once per
+	// instance, against the master database.
+	masterOnly = true
+	// everyDatabase collectors query per-database catalogs and run against
+	// every (auto-discovered) database.
+	everyDatabase = false
+)
+
+// Collector emits metrics which can not be expressed as a column mapping,
+// e.g. because they are computed from several rows or depend on the server
+// version in ways the mappings can't describe.
+type Collector interface {
+	// Update queries the server and sends the resulting metrics to ch.
+	Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error
+}
+
+// collectorConfig holds the exporter options which apply to the collectors.
+type collectorConfig struct {
+	excludedDatabases []string // Databases to skip, from --exclude-databases
+}
+
+// newCollectorConfig returns the configuration of the collectors. Empty
+// database names are ignored.
+func newCollectorConfig(excludedDatabases []string) collectorConfig {
+	var config collectorConfig
+	for _, name := range excludedDatabases {
+		if name = strings.TrimSpace(name); name != "" {
+			config.excludedDatabases = append(config.excludedDatabases, name)
+		}
+	}
+	return config
+}
+
+// isExcluded returns whether the metrics of the database must be skipped.
+func (c collectorConfig) isExcluded(datname string) bool {
+	return contains(c.excludedDatabases, datname)
+}
+
+// collectorSpec describes a registered collector.
+type collectorSpec struct {
+	master         bool             // Run only against the master database
+	defaultMetrics bool             // Replaces builtin metrics, skipped with --disable-default-metrics
+	factory        func() Collector // Creates a new collector instance for a server
+	timeout        *time.Duration   // Maximum duration of a run, 0 uses --collector.timeout
+}
+
+var (
+	collectorSpecs = make(map[string]collectorSpec)
+	collectorState = make(map[string]*bool)
+)
+
+// registerCollector makes a collector available under the given name and
+// adds a --collector.<name> flag to enable or disable it, and a
+// --collector.<name>.timeout flag to override the collector timeout.
+func registerCollector(name string, isDefaultEnabled, master bool, factory func() Collector) {
+	helpDefaultState := "disabled"
+	if isDefaultEnabled {
+		helpDefaultState = "enabled"
+	}
+
+	flagName := fmt.Sprintf("collector.%s", name)
+	flagHelp := fmt.Sprintf("Enable the %s collector (default: %s).", name, helpDefaultState)
+	defaultValue := fmt.Sprintf("%v", isDefaultEnabled)
+
+	timeoutFlagName := fmt.Sprintf("collector.%s.timeout", name)
+	timeoutFlagHelp := fmt.Sprintf("Maximum duration of a %s collector run, 0 uses --collector.timeout.", name)
+
+	collectorState[name] = kingpin.Flag(flagName, flagHelp).Default(defaultValue).Bool()
+	collectorSpecs[name] = collectorSpec{
+		master:  master,
+		factory: factory,
+		timeout: kingpin.Flag(timeoutFlagName, timeoutFlagHelp).Default("0s").Duration(),
+	}
+}
+
+// registerDefaultMetricsCollector registers a collector like
+// registerCollector, for a collector which replaces builtin metric maps. Like
+// them, it doesn't run with --disable-default-metrics.
+func registerDefaultMetricsCollector(name string, isDefaultEnabled, master bool, factory func() Collector) {
+	registerCollector(name, isDefaultEnabled, master, factory)
+	spec := collectorSpecs[name]
+	spec.defaultMetrics = true
+	collectorSpecs[name] = spec
+}
+
+// enabledCollectors returns the sorted names of the collectors enabled by flags.
+func enabledCollectors() []string {
+	var names []string
+	for name, enabled := range collectorState {
+		if *enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// serverCollector is a collector instance bound to a server.
+type serverCollector struct {
+	name           string
+	master         bool
+	defaultMetrics bool
+	timeout        time.Duration
+	// Time in Unix nanoseconds until which the collector is disabled, after
+	// it failed for lack of privileges. Accessed atomically, as scrapes may
+	// run concurrently.
+	deniedUntil int64
+	Collector
+}
+
+// newServerCollectors instantiates the named collectors. Every server gets
+// its own instances so collectors may keep state between scrapes.
+func newServerCollectors(names []string) ([]serverCollector, error) {
+	collectors := make([]serverCollector, 0, len(names))
+	for _, name := range names {
+		spec, ok := collectorSpecs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		collectors = append(collectors, serverCollector{
+			name:           name,
+			master:         spec.master,
+			defaultMetrics: spec.defaultMetrics,
+			timeout:        *spec.timeout,
+			Collector:      spec.factory(),
+		})
+	}
+	return collectors, nil
+}
+
+// Run all the collectors of the server and emit their metrics. Every
+// collector gets its own deadline, so a slow one doesn't stall the others.
+// Returns a map of collector -> error for the collectors which failed. The
+// targets of /probe are not kept in the internal metrics, which would grow
+// with every target ever probed.
+func (e *Exporter) runCollectors(ch chan<- prometheus.Metric, server *Server) map[string]error {
+	collectorErrors := make(map[string]error)
+
+	for i := range server.collectors {
+		c := &server.collectors[i]
+		if c.master && !server.master {
+			continue
+		}
+		if c.defaultMetrics && e.disableDefaultMetrics {
+			continue
+		}
+		if time.Now().UnixNano() < atomic.LoadInt64(&c.deniedUntil) {
+			continue
+		}
+
+		timeout := c.timeout
+		if timeout == 0 {
+			timeout = e.collectorTimeout
+		}
+
+		ctx, cancel := context.Background(), func() {}
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+
+		server.queryLimiter.acquire(server.String())
+		start := time.Now()
+		err := e.updateCollector(ctx, c, server, ch)
+		server.queryLimiter.release(server.String())
+		if !server.probe {
+			e.collectorDuration.WithLabelValues(c.name, server.String()).Set(time.Since(start).Seconds())
+			if err != nil {
+				e.collectorSuccess.WithLabelValues(c.name, server.String()).Set(0)
+			} else {
+				e.collectorSuccess.WithLabelValues(c.name, server.String()).Set(1)
+			}
+		}
+		if isPermissionDenied(err) {
+			// Retrying on every scrape would only repeat the same error, the
+			// privileges are checked again after the backoff.
+			if atomic.SwapInt64(&c.deniedUntil, time.Now().Add(e.deniedBackoff).UnixNano()) == 0 {
+				log.Warnf("Disabling collector %s on %q for %s, permission denied: %v", c.name, server, e.deniedBackoff, err)
+			}
+			if !server.probe {
+				e.collectorDenied.WithLabelValues(c.name, server.String()).Set(1)
+			}
+			err = nil
+		} else if atomic.SwapInt64(&c.deniedUntil, 0) != 0 {
+			log.Infof("Enabling collector %s on %q again, it isn't denied anymore", c.name, server)
+			if !server.probe {
+				e.collectorDenied.WithLabelValues(c.name, server.String()).Set(0)
+			}
+		}
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				if !server.probe {
+					e.collectorTimeouts.WithLabelValues(c.name, server.String()).Inc()
+				}
+				err = fmt.Errorf("timed out after %s: %v", timeout, err)
+			}
+			collectorErrors[c.name] = fmt.Errorf("collector %s failed on %q: %v", c.name, server, err)
+		}
+		cancel()
+	}
+
+	return collectorErrors
+}
+
+// defaultCollectorRetryWait is the time waited before retrying a collector.
+const defaultCollectorRetryWait = 500 * time.Millisecond
+
+// defaultDeniedBackoff is the time a collector is disabled after it failed for
+// lack of privileges, which may be granted in the meantime.
+const defaultDeniedBackoff = 10 * time.Minute
+
+// updateCollector runs a collector, and retries it up to --collector.retries
+// times after a transient error. The metrics of an attempt are buffered when
+// retries are enabled, so that a retried attempt doesn't report them twice.
+func (e *Exporter) updateCollector(ctx context.Context, c *serverCollector, server *Server, ch chan<- prometheus.Metric) error {
+	if e.collectorRetries == 0 {
+		err := c.Update(ctx, server, ch)
+		if err != nil && !server.probe {
+			e.collectorErrors.WithLabelValues(c.name, server.String(), classifyCollectorError(ctx, err)).Inc()
+		}
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		metrics, err := bufferedUpdate(ctx, c, server)
+		if err != nil {
+			class := classifyCollectorError(ctx, err)
+			if !server.probe {
+				e.collectorErrors.WithLabelValues(c.name, server.String(), class).Inc()
+			}
+
+			if class == collectorErrorTransient && attempt < e.collectorRetries {
+				log.Infof("Retrying collector %s on %q after a transient error: %v", c.name, server, err)
+				select {
+				case <-time.After(e.collectorRetryWait):
+					continue
+				case <-ctx.Done():
+				}
+			}
+		}
+
+		for _, metric := range metrics {
+			ch <- metric
+		}
+		return err
+	}
+}
+
+// bufferedUpdate runs a collector and returns its metrics.
+func bufferedUpdate(ctx context.Context, c *serverCollector, server *Server) ([]prometheus.Metric, error) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var metrics []prometheus.Metric
+	go func() {
+		for metric := range ch {
+			metrics = append(metrics, metric)
+		}
+		close(done)
+	}()
+
+	err := c.Update(ctx, server, ch)
+	close(ch)
+	<-done
+	return metrics, err
+}
+
+// Coarse classes of the collector errors, the class label of
+// pg_collector_errors_total.
+const (
+	collectorErrorTransient        = "transient"
+	collectorErrorTimeout          = "timeout"
+	collectorErrorPermissionDenied = "permission_denied"
+	collectorErrorOther            = "other"
+)
+
+// classifyCollectorError returns the class of an error of a collector run
+// with the given context.
+func classifyCollectorError(ctx context.Context, err error) string {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return collectorErrorTimeout
+	case isPermissionDenied(err):
+		return collectorErrorPermissionDenied
+	case isTransient(err):
+		return collectorErrorTransient
+	default:
+		return collectorErrorOther
+	}
+}
+
+// insufficientPrivilege is the SQLSTATE of permission denied errors.
+const insufficientPrivilege = "42501"
+
+// isPermissionDenied returns whether the error is a permission denied error
+// of lib/pq or pgx.
+func isPermissionDenied(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == insufficientPrivilege
+	}
+	var pgxErr interface{ SQLState() string }
+	if errors.As(err, &pgxErr) {
+		return pgxErr.SQLState() == insufficientPrivilege
+	}
+	return false
+}
+
+// isTransient returns whether the error is likely to go away on retry, e.g.
+// while a server shuts down or starts up during a failover: the connection
+// exceptions (class 08), admin_shutdown, crash_shutdown and cannot_connect_now.
+func isTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	var code string
+	var pqErr *pq.Error
+	var pgxErr interface{ SQLState() string }
+	switch {
+	case errors.As(err, &pqErr):
+		code = string(pqErr.Code)
+	case errors.As(err, &pgxErr):
+		code = pgxErr.SQLState()
+	default:
+		return false
+	}
+
+	switch code {
+	case "57P01", "57P02", "57P03":
+		return true
+	}
+	return strings.HasPrefix(code, "08")
+}
@@ -0,0 +1,315 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/blang/semver"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	. "gopkg.in/check.v1"
+)
+
+type CollectorSuite struct{}
+
+var _ = Suite(&CollectorSuite{})
+
+// metricResult is a flattened representation of a prometheus.Metric.
+type metricResult struct {
+	name   string
+	labels map[string]string
+	value  float64
+	vtype  dto.MetricType
+}
+
+var fqNameRegexp = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+func readMetric(c *C, m prometheus.Metric) metricResult {
+	pb := &dto.Metric{}
+	c.Assert(m.Write(pb), IsNil)
+
+	r := metricResult{
+		name:   fqNameRegexp.FindStringSubmatch(m.Desc().String())[1],
+		labels: make(map[string]string),
+	}
+	for _, l := range pb.Label {
+		r.labels[l.GetName()] = l.GetValue()
+	}
+
+	switch {
+	case pb.Gauge != nil:
+		r.value, r.vtype = pb.Gauge.GetValue(), dto.MetricType_GAUGE
+	case pb.Counter != nil:
+		r.value, r.vtype = pb.Counter.GetValue(), dto.MetricType_COUNTER
+	case pb.Untyped != nil:
+		r.value, r.vtype = pb.Untyped.GetValue(), dto.MetricType_UNTYPED
+	}
+	return r
+}
+
+// newMockServer returns a master server backed by sqlmock which expects
+// queries to match exactly.
+func newMockServer(c *C, version string) (*Server, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	c.Assert(err, IsNil)
+
+	server := &Server{
+		db:             db,
+		labels:         prometheus.Labels{serverLabelName: "test:5432"},
+		master:         true,
+		lastMapVersion: semver.MustParse(version),
+	}
+	return server, mock
+}
+
+// collectMetrics runs a single collector update and returns the emitted metrics.
+func collectMetrics(c *C, collector Collector, server *Server) []metricResult {
+	ch := make(chan prometheus.Metric)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- collector.Update(context.Background(), server, ch)
+		close(ch)
+	}()
+
+	var results []metricResult
+	for m := range ch {
+		results = append(results, readMetric(c, m))
+	}
+	c.Assert(<-errCh, IsNil)
+	return results
+}
+
+func (s *CollectorSuite) TestNewServerCollectors(c *C) {
+	collectors, err := newServerCollectors([]string{"invalid_indexes", "replication_slots"})
+	c.Assert(err, IsNil)
+	c.Assert(collectors, HasLen, 2)
+	c.Assert(collectors[0].name, Equals, "invalid_indexes")
+	c.Assert(collectors[0].master, Equals, everyDatabase)
+	c.Assert(collectors[1].name, Equals, "replication_slots")
+	c.Assert(collectors[1].master, Equals, masterOnly)
+
+	_, err = newServerCollectors([]string{"unknown"})
+	c.Assert(err, ErrorMatches, `unknown collector "unknown"`)
+}
+
+func (s *CollectorSuite) TestCollectorEnabled(c *C) {
+	e := NewExporter(nil, WithCollectors([]string{"stat_io", "wal"}))
+
+	c.Assert(testutil.ToFloat64(e.collectorEnabled.WithLabelValues("stat_io")), Equals, 1.0)
+	c.Assert(testutil.ToFloat64(e.collectorEnabled.WithLabelValues("wal")), Equals, 1.0)
+	c.Assert(testutil.ToFloat64(e.collectorEnabled.WithLabelValues("settings")), Equals, 0.0)
+	c.Assert(testutil.CollectAndCount(e.collectorEnabled), Equals, len(collectorSpecs))
+}
+
+func (s *CollectorSuite) TestCollectorConfigIsExcluded(c *C) {
+	config := newCollectorConfig([]string{"", " scratch", "archive"})
+	c.Assert(config.excludedDatabases, DeepEquals, []string{"scratch", "archive"})
+	c.Assert(config.isExcluded("scratch"), Equals, true)
+	c.Assert(config.isExcluded("app"), Equals, false)
+	// Locks on shared objects have an empty datname, they are never excluded.
+	c.Assert(newCollectorConfig([]string{""}).isExcluded(""), Equals, false)
+}
+
+func (s *CollectorSuite) TestRunCollectorsSkipsMasterOnly(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	server.master = false
+	server.collectors, _ = newServerCollectors([]string{"replication_slots"})
+
+	e := NewExporter(nil)
+	ch := make(chan prometheus.Metric, 1)
+	c.Assert(e.runCollectors(ch, server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+// blockingCollector waits until its context is done.
+type blockingCollector struct{}
+
+func (blockingCollector) Update(ctx context.Context, _ *Server, _ chan<- prometheus.Metric) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// constCollector emits a single gauge.
+type constCollector struct{}
+
+func (constCollector) Update(_ context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	ch <- prometheus.MustNewConstMetric(newDesc("test", "const", "Constant", server.labels), prometheus.GaugeValue, 1)
+	return nil
+}
+
+func (s *CollectorSuite) TestRunCollectorsTimeout(c *C) {
+	server, _ := newMockServer(c, "13.0.0")
+	server.collectors = []serverCollector{
+		{name: "blocking", Collector: blockingCollector{}},
+		{name: "const", Collector: constCollector{}},
+	}
+
+	e := NewExporter(nil, WithCollectorTimeout(10*time.Millisecond))
+	ch := make(chan prometheus.Metric, 1)
+	errs := e.runCollectors(ch, server)
+
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errs["blocking"], ErrorMatches, `collector blocking failed on "test:5432": timed out after 10ms: context deadline exceeded`)
+	c.Assert(readMetric(c, <-ch).name, Equals, "pg_test_const")
+	c.Assert(testutil.ToFloat64(e.collectorTimeouts.WithLabelValues("blocking", "test:5432")), Equals, 1.0)
+	c.Assert(readMetric(c, e.collectorTimeouts.WithLabelValues("blocking", "test:5432")).name, Equals, "pg_collector_timeout_total")
+
+	c.Assert(testutil.ToFloat64(e.collectorSuccess.WithLabelValues("blocking", "test:5432")), Equals, 0.0)
+	c.Assert(testutil.ToFloat64(e.collectorSuccess.WithLabelValues("const", "test:5432")), Equals, 1.0)
+	c.Assert(testutil.ToFloat64(e.collectorDuration.WithLabelValues("blocking", "test:5432")) >= 0.01, Equals, true)
+}
+
+func (s *CollectorSuite) TestRunCollectorsPerCollectorTimeout(c *C) {
+	server, _ := newMockServer(c, "13.0.0")
+	server.collectors = []serverCollector{
+		{name: "slow", timeout: 10 * time.Millisecond, Collector: blockingCollector{}},
+		{name: "const", Collector: constCollector{}},
+	}
+
+	// The collector timeout overrides the global one, which is disabled here.
+	e := NewExporter(nil)
+	ch := make(chan prometheus.Metric, 1)
+	errs := e.runCollectors(ch, server)
+
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errs["slow"], ErrorMatches, `collector slow failed on "test:5432": timed out after 10ms: context deadline exceeded`)
+	c.Assert(readMetric(c, <-ch).name, Equals, "pg_test_const")
+	c.Assert(testutil.ToFloat64(e.collectorTimeouts.WithLabelValues("slow", "test:5432")), Equals, 1.0)
+}
+
+func (s *CollectorSuite) TestRunCollectorsDisableDefaultMetrics(c *C) {
+	server, _ := newMockServer(c, "13.0.0")
+	locks := &deniedCollector{}
+	server.collectors = []serverCollector{
+		{name: "locks", defaultMetrics: true, Collector: locks},
+		{name: "const", Collector: constCollector{}},
+	}
+
+	// The collectors replacing builtin metrics are skipped.
+	e := NewExporter(nil, DisableDefaultMetrics(true))
+	ch := make(chan prometheus.Metric, 1)
+	c.Assert(e.runCollectors(ch, server), HasLen, 0)
+	c.Assert(readMetric(c, <-ch).name, Equals, "pg_test_const")
+	c.Assert(locks.calls, Equals, 0)
+	c.Assert(collectorSpecs["locks"].defaultMetrics, Equals, true)
+	c.Assert(collectorSpecs["wal"].defaultMetrics, Equals, false)
+}
+
+// deniedCollector fails with a permission denied error, like a collector
+// querying a function the user isn't allowed to call, until it is granted.
+type deniedCollector struct {
+	calls   int
+	granted bool
+}
+
+func (d *deniedCollector) Update(context.Context, *Server, chan<- prometheus.Metric) error {
+	d.calls++
+	if d.granted {
+		return nil
+	}
+	return &pq.Error{Code: "42501", Message: "permission denied for function pg_ls_waldir"}
+}
+
+func (s *CollectorSuite) TestRunCollectorsPermissionDenied(c *C) {
+	server, _ := newMockServer(c, "13.0.0")
+	denied := &deniedCollector{}
+	server.collectors = []serverCollector{
+		{name: "wal", Collector: denied},
+		{name: "const", Collector: constCollector{}},
+	}
+
+	e := NewExporter(nil)
+	for i := 0; i < 2; i++ {
+		ch := make(chan prometheus.Metric, 1)
+		c.Assert(e.runCollectors(ch, server), HasLen, 0)
+		c.Assert(readMetric(c, <-ch).name, Equals, "pg_test_const")
+	}
+
+	// The collector is disabled after the first failure.
+	c.Assert(denied.calls, Equals, 1)
+	c.Assert(testutil.ToFloat64(e.collectorDenied.WithLabelValues("wal", "test:5432")), Equals, 1.0)
+
+	// It runs again after the backoff, and is enabled once it succeeds.
+	server.collectors[0].deniedUntil = time.Now().UnixNano()
+	ch := make(chan prometheus.Metric, 1)
+	c.Assert(e.runCollectors(ch, server), HasLen, 0)
+	c.Assert(denied.calls, Equals, 2)
+	c.Assert(testutil.ToFloat64(e.collectorDenied.WithLabelValues("wal", "test:5432")), Equals, 1.0)
+
+	denied.granted = true
+	server.collectors[0].deniedUntil = time.Now().UnixNano()
+	ch = make(chan prometheus.Metric, 1)
+	c.Assert(e.runCollectors(ch, server), HasLen, 0)
+	c.Assert(denied.calls, Equals, 3)
+	c.Assert(server.collectors[0].deniedUntil, Equals, int64(0))
+	c.Assert(testutil.ToFloat64(e.collectorDenied.WithLabelValues("wal", "test:5432")), Equals, 0.0)
+	c.Assert(isPermissionDenied(fmt.Errorf("wrapped: %w", &pq.Error{Code: "42501"})), Equals, true)
+	c.Assert(isPermissionDenied(&pq.Error{Code: "42P01"}), Equals, false)
+}
+
+// flakyCollector emits a gauge, then fails with a transient error until it
+// has been called failures times.
+type flakyCollector struct {
+	calls, failures int
+}
+
+func (f *flakyCollector) Update(_ context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	f.calls++
+	ch <- prometheus.MustNewConstMetric(newDesc("test", "flaky", "Flaky", server.labels), prometheus.GaugeValue, float64(f.calls))
+	if f.calls <= f.failures {
+		return &pq.Error{Code: "57P03", Message: "the database system is starting up"}
+	}
+	return nil
+}
+
+func (s *CollectorSuite) TestRunCollectorsRetries(c *C) {
+	server, _ := newMockServer(c, "13.0.0")
+	flaky := &flakyCollector{failures: 1}
+	server.collectors = []serverCollector{{name: "flaky", Collector: flaky}}
+
+	e := NewExporter(nil, WithCollectorRetries(2))
+	e.collectorRetryWait = 0
+	ch := make(chan prometheus.Metric, 2)
+	c.Assert(e.runCollectors(ch, server), HasLen, 0)
+
+	// Only the metrics of the successful attempt are reported.
+	c.Assert(flaky.calls, Equals, 2)
+	c.Assert(ch, HasLen, 1)
+	c.Assert(readMetric(c, <-ch).value, Equals, 2.0)
+	c.Assert(testutil.ToFloat64(e.collectorErrors.WithLabelValues("flaky", "test:5432", "transient")), Equals, 1.0)
+	c.Assert(readMetric(c, e.collectorErrors.WithLabelValues("flaky", "test:5432", "transient")).name, Equals, "pg_collector_errors_total")
+
+	// The last attempt fails once the retries are exhausted.
+	flaky = &flakyCollector{failures: 5}
+	server.collectors = []serverCollector{{name: "flaky", Collector: flaky}}
+	errs := e.runCollectors(ch, server)
+	c.Assert(errs["flaky"], ErrorMatches, `collector flaky failed on "test:5432": pq: the database system is starting up`)
+	c.Assert(flaky.calls, Equals, 3)
+	c.Assert(ch, HasLen, 1)
+	c.Assert(testutil.ToFloat64(e.collectorErrors.WithLabelValues("flaky", "test:5432", "transient")), Equals, 4.0)
+}
+
+func (s *CollectorSuite) TestRunCollectorsNoRetries(c *C) {
+	server, _ := newMockServer(c, "13.0.0")
+	flaky := &flakyCollector{failures: 1}
+	server.collectors = []serverCollector{{name: "flaky", Collector: flaky}}
+
+	e := NewExporter(nil)
+	ch := make(chan prometheus.Metric, 1)
+	c.Assert(e.runCollectors(ch, server), HasLen, 1)
+	c.Assert(flaky.calls, Equals, 1)
+	c.Assert(testutil.ToFloat64(e.collectorErrors.WithLabelValues("flaky", "test:5432", "transient")), Equals, 1.0)
+
+	c.Assert(isTransient(fmt.Errorf("wrapped: %w", &pq.Error{Code: "08006"})), Equals, true)
+	c.Assert(isTransient(driver.ErrBadConn), Equals, true)
+	c.Assert(isTransient(&pq.Error{Code: "42P01"}), Equals, false)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	_                  = kingpin.Command("run", "Run the exporter (default).").Default()
+	checkConfigCommand = kingpin.Command("check-config", "Check the configuration files and the custom queries without connecting to PostgreSQL, and exit.")
+)
+
+// checkConfig reads the configuration of the exporter like it would be at
+// startup, without connecting to any database, and returns all the problems
+// found: the data sources, the --probe.auth-file, --config.relabel-file and
+// --config.data-source-file files, the web configuration and the custom
+// query files of the enabled resolutions.
+func checkConfig(e *Exporter, probeAuthPath string) []error {
+	var errs []error
+
+	if _, err := readProbeConfig(probeAuthPath); err != nil {
+		errs = append(errs, fmt.Errorf("can't read --probe.auth-file: %s", err))
+	}
+	if _, err := readRelabelConfigs(*relabelConfigFile); err != nil {
+		errs = append(errs, fmt.Errorf("can't read --config.relabel-file: %s", err))
+	}
+
+	if _, err := e.reloadDataSourceFile(); err != nil {
+		errs = append(errs, fmt.Errorf("can't read --config.data-source-file: %s", err))
+	}
+	dsns := e.dataSources()
+	if len(dsns) == 0 && e.dataSourceFile == "" && probeAuthPath == "" {
+		errs = append(errs, errors.New("couldn't find environment variables describing the datasource to use"))
+	}
+	for i, dsn := range dsns {
+		// The error would include the password of the DSN.
+		if _, err := parseFingerprint(dsn); err != nil {
+			errs = append(errs, fmt.Errorf("data source #%d is malformed", i+1))
+		}
+	}
+
+	if err := checkSettingsCollector(e); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := webTLSEnabled(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := loadBasicAuth(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkWebConfigFile(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return append(errs, e.checkCustomQueries()...)
+}
+
+// checkCustomQueries checks the custom query files of the enabled resolutions
+// with checkUserQueries, and checkUnsafeQueries if unsafe queries are
+// rejected, and returns the problems found prefixed with the path of their
+// file.
+func (e *Exporter) checkCustomQueries() []error {
+	var errs []error
+	for _, res := range []MetricResolution{HR, MR, LR} {
+		dir := e.userQueriesPath[res]
+		if dir == "" || !e.userQueriesEnabled[res] {
+			continue
+		}
+
+		files, err := customQueriesFiles(dir, e.userQueriesExclude[res])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't list the custom query files of %s: %s", dir, err))
+			continue
+		}
+		for _, path := range files {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			queryErrs := checkUserQueries(content)
+			if len(queryErrs) == 0 && e.rejectUnsafeQuery {
+				if err := checkUnsafeQueries(content); err != nil {
+					queryErrs = append(queryErrs, err)
+				}
+			}
+			for _, err := range queryErrs {
+				errs = append(errs, fmt.Errorf("%s: %s", path, err))
+			}
+		}
+	}
+	return errs
+}
+
+// checkUserQueries parses custom queries like parseUserQueries, and returns
+// the YAML error, or the problems found in each query prefixed with its name:
+// an empty query, no metrics, an unknown column usage, an invalid metric or
+// label name, or a column named after the columns of a HISTOGRAM. A HISTOGRAM
+// column <name> is read from the <name>_bucket, <name>_sum and <name>_count
+// columns of the result, with one row per bucket and its upper bound in le.
+func checkUserQueries(content []byte) []error {
+	var userQueries UserQueries
+	if err := yaml.Unmarshal(content, &userQueries); err != nil {
+		return []error{err}
+	}
+
+	names := make([]string, 0, len(userQueries))
+	for name := range userQueries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		query := userQueries[name]
+		fail := func(format string, args ...interface{}) {
+			errs = append(errs, fmt.Errorf("query %s: %s", name, fmt.Sprintf(format, args...)))
+		}
+
+		if !model.IsValidMetricName(model.LabelValue(name)) {
+			fail("invalid metric name")
+		}
+		if strings.TrimSpace(query.Query) == "" {
+			fail("the query is empty")
+		}
+		if len(query.Metrics) == 0 {
+			fail("no metrics are defined")
+		}
+		histogramColumnNames := make(map[string]string)
+		for _, metric := range query.Metrics {
+			for column, options := range metric {
+				if options.Usage == "HISTOGRAM" {
+					for _, histogramColumn := range histogramColumns(column) {
+						histogramColumnNames[histogramColumn] = column
+					}
+				}
+			}
+		}
+
+		for _, metric := range query.Metrics {
+			columns := make([]string, 0, len(metric))
+			for column := range metric {
+				columns = append(columns, column)
+			}
+			sort.Strings(columns)
+
+			for _, column := range columns {
+				usage, err := stringToColumnUsage(metric[column].Usage)
+				switch {
+				case err != nil:
+					fail("column %s: unknown usage %q", column, metric[column].Usage)
+				case histogramColumnNames[column] != "":
+					fail("column %s: reserved for the HISTOGRAM column %s", column, histogramColumnNames[column])
+				case usage == LABEL && !model.LabelName(column).IsValid():
+					fail("column %s: invalid label name", column)
+				case usage != LABEL && usage != DISCARD && !model.IsValidMetricName(model.LabelValue(name+"_"+column)):
+					fail("column %s: invalid metric name %s", column, name+"_"+column)
+				}
+			}
+		}
+	}
+	return errs
+}
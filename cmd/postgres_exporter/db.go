@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/lib/pq"
+)
+
+// openDB opens a database handle for the DSN with the given driver. If
+// tlsServerName is set, it is used instead of the host of the DSN as the TLS
+// server name, both for SNI and to verify the server certificate with
+// sslmode=verify-full. This allows connecting through a proxy or load
+// balancer to a server with a certificate for a different host name. If
+// initSQL is set, it is run on each new connection before it is used.
+func openDB(driverName, dsn, tlsServerName, initSQL string) (*sql.DB, error) {
+	if tlsServerName == "" && initSQL == "" {
+		return sql.Open(sqlDriverNames[driverName], dsn)
+	}
+
+	switch driverName {
+	case driverPGX:
+		config, err := pgxConfigWithTLSServerName(dsn, tlsServerName)
+		if err != nil {
+			return nil, err
+		}
+		var opts []stdlib.OptionOpenDB
+		if initSQL != "" {
+			opts = append(opts, stdlib.OptionAfterConnect(func(ctx context.Context, conn *pgx.Conn) error {
+				if _, err := conn.Exec(ctx, initSQL); err != nil {
+					return fmt.Errorf("error running the init SQL: %w", err)
+				}
+				return nil
+			}))
+		}
+		return stdlib.OpenDB(*config, opts...), nil
+	case driverPQ:
+		connector, err := pqConnectorWithTLSServerName(dsn, tlsServerName)
+		if err != nil {
+			return nil, err
+		}
+		if initSQL != "" {
+			connector = &initSQLConnector{Connector: connector, initSQL: initSQL}
+		}
+		return sql.OpenDB(connector), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driverName)
+	}
+}
+
+// pgxConfigWithTLSServerName parses the DSN and overrides the TLS server name
+// of the host and of its fallbacks, if set.
+func pgxConfigWithTLSServerName(dsn, tlsServerName string) (*pgx.ConnConfig, error) {
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if tlsServerName == "" {
+		return config, nil
+	}
+
+	if config.TLSConfig != nil {
+		config.TLSConfig.ServerName = tlsServerName
+	}
+	for _, fallback := range config.Fallbacks {
+		if fallback.TLSConfig != nil {
+			fallback.TLSConfig.ServerName = tlsServerName
+		}
+	}
+	return config, nil
+}
+
+// lib/pq uses the host of the DSN as the TLS server name, and has no option
+// to override it. So the host is replaced by the server name, and the
+// connections are dialed to the address of the actual host instead. Without a
+// server name, the DSN is used as is.
+func pqConnectorWithTLSServerName(dsn, tlsServerName string) (driver.Connector, error) {
+	if tlsServerName == "" {
+		return pq.NewConnector(dsn)
+	}
+
+	address, err := parseFingerprint(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(address, "/") {
+		return nil, fmt.Errorf("TLS server name can't be used with Unix socket %q", address)
+	}
+
+	if pqDSN, err := pq.ParseURL(dsn); err == nil {
+		dsn = pqDSN
+	}
+
+	return &pqConnector{
+		dsn:    fmt.Sprintf("%s host=%s", dsn, tlsServerName),
+		dialer: addressDialer{address: address},
+	}, nil
+}
+
+// pqConnector is a driver.Connector for lib/pq using a custom dialer.
+type pqConnector struct {
+	dsn    string
+	dialer pq.Dialer
+}
+
+// Connect implements driver.Connector.
+func (c *pqConnector) Connect(context.Context) (driver.Conn, error) {
+	return pq.DialOpen(c.dialer, c.dsn)
+}
+
+// Driver implements driver.Connector.
+func (c *pqConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// initSQLConnector is a driver.Connector running SQL on each new connection,
+// for lib/pq which has no connection hook.
+type initSQLConnector struct {
+	driver.Connector
+	initSQL string
+}
+
+// Connect implements driver.Connector.
+func (c *initSQLConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close() // nolint: errcheck
+		return nil, fmt.Errorf("connection of type %T can't run the init SQL", conn)
+	}
+	if _, err := execer.ExecContext(ctx, c.initSQL, nil); err != nil {
+		conn.Close() // nolint: errcheck
+		return nil, fmt.Errorf("error running the init SQL: %w", err)
+	}
+	return conn, nil
+}
+
+// addressDialer dials a fixed address, whatever address is requested.
+type addressDialer struct {
+	address string
+	dialer  net.Dialer
+}
+
+// Dial implements pq.Dialer.
+func (d addressDialer) Dial(network, _ string) (net.Conn, error) {
+	return d.dialer.Dial(network, d.address)
+}
+
+// DialTimeout implements pq.Dialer.
+func (d addressDialer) DialTimeout(network, _ string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(network, d.address, timeout)
+}
+
+// DialContext implements pq.DialerContext.
+func (d addressDialer) DialContext(ctx context.Context, network, _ string) (net.Conn, error) {
+	return d.dialer.DialContext(ctx, network, d.address)
+}
+
+// withDefaultParams adds the connection parameters to the DSN, unless it
+// already sets them. Both URL and key/value DSNs are supported.
+func withDefaultParams(dsn string, params map[string]string) (string, error) {
+	keys := make([]string, 0, len(params))
+	for key, value := range params {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return dsn, nil
+	}
+	sort.Strings(keys)
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		query := u.Query()
+		for _, key := range keys {
+			if _, ok := query[key]; !ok {
+				query.Set(key, params[key])
+			}
+		}
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+
+	present := make(map[string]bool)
+	for _, pair := range strings.Fields(dsn) {
+		if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 {
+			present[strings.TrimSpace(kv[0])] = true
+		}
+	}
+	for _, key := range keys {
+		if !present[key] {
+			dsn += fmt.Sprintf(" %s=%s", key, quoteDSNValue(params[key]))
+		}
+	}
+	return strings.TrimSpace(dsn), nil
+}
+
+// quoteDSNValue quotes a value of a key/value DSN if needed.
+func quoteDSNValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// sessionTimeoutsSQL returns the SQL setting the statement_timeout and
+// lock_timeout of a connection. A timeout of 0 is left out, and the others
+// are rounded up to the millisecond, as 0 would disable them.
+func sessionTimeoutsSQL(statementTimeout, lockTimeout time.Duration) string {
+	var statements []string
+	for _, setting := range []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"statement_timeout", statementTimeout},
+		{"lock_timeout", lockTimeout},
+	} {
+		if setting.timeout > 0 {
+			ms := (setting.timeout + time.Millisecond - 1) / time.Millisecond
+			statements = append(statements, fmt.Sprintf("SET %s = %d", setting.name, ms))
+		}
+	}
+	return strings.Join(statements, "; ")
+}
+
+// queryLimiter limits the number of queries run at the same time on each
+// target, a host and port shared by the servers of its databases. A nil
+// limiter doesn't limit anything.
+type queryLimiter struct {
+	limit int
+	mtx   sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func newQueryLimiter(limit int) *queryLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &queryLimiter{
+		limit: limit,
+		slots: make(map[string]chan struct{}),
+	}
+}
+
+// acquire blocks until another query may be run on the target. Every call
+// must be followed by release.
+func (l *queryLimiter) acquire(target string) {
+	if l == nil {
+		return
+	}
+	l.targetSlots(target) <- struct{}{}
+}
+
+func (l *queryLimiter) release(target string) {
+	if l == nil {
+		return
+	}
+	<-l.targetSlots(target)
+}
+
+func (l *queryLimiter) targetSlots(target string) chan struct{} {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	slots, ok := l.slots[target]
+	if !ok {
+		slots = make(chan struct{}, l.limit)
+		l.slots[target] = slots
+	}
+	return slots
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("autovacuum_config", defaultDisabled, masterOnly, newAutovacuumConfigCollector)
+	registerCollector("autovacuum_table_config", defaultDisabled, everyDatabase, newAutovacuumTableConfigCollector)
+}
+
+const autovacuumSubsystem = "autovacuum"
+
+// autovacuum_vacuum_cost_limit is -1 by default, which means that
+// vacuum_cost_limit is used.
+const autovacuumSettingsQuery = `
+SELECT
+	(SELECT setting::float FROM pg_settings WHERE name = 'autovacuum_naptime') AS naptime,
+	(SELECT CASE WHEN a.setting::int < 0 THEN v.setting::float ELSE a.setting::float END
+		FROM pg_settings a, pg_settings v
+		WHERE a.name = 'autovacuum_vacuum_cost_limit' AND v.name = 'vacuum_cost_limit') AS vacuum_cost_limit
+`
+
+// Only the tables which override the cost limit in their storage parameters
+// are reported, the others use the setting.
+const autovacuumTablesQuery = `
+SELECT
+	current_database() AS datname,
+	n.nspname AS schemaname,
+	c.relname,
+	split_part(o, '=', 2)::float AS vacuum_cost_limit
+FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace,
+	unnest(c.reloptions) AS o
+WHERE o LIKE 'autovacuum_vacuum_cost_limit=%'
+`
+
+// autovacuumConfigCollector reports the settings of autovacuum, which are
+// the same for all the databases of the server.
+type autovacuumConfigCollector struct{}
+
+func newAutovacuumConfigCollector() Collector {
+	return &autovacuumConfigCollector{}
+}
+
+// Update implements Collector.
+func (c *autovacuumConfigCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	var naptime, costLimit float64
+	if err := server.db.QueryRowContext(ctx, autovacuumSettingsQuery).Scan(&naptime, &costLimit); err != nil {
+		return err
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		newDesc(autovacuumSubsystem, "naptime_seconds", "Minimum delay between autovacuum runs on any given database", server.labels),
+		prometheus.GaugeValue, naptime,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		newDesc(autovacuumSubsystem, "vacuum_cost_limit", "Cost limit of autovacuum, from autovacuum_vacuum_cost_limit or vacuum_cost_limit", server.labels),
+		prometheus.GaugeValue, costLimit,
+	)
+	return nil
+}
+
+// autovacuumTableConfigCollector reports the cost limits of autovacuum set
+// on the tables of each database.
+type autovacuumTableConfigCollector struct{}
+
+func newAutovacuumTableConfigCollector() Collector {
+	return &autovacuumTableConfigCollector{}
+}
+
+// Update implements Collector.
+func (c *autovacuumTableConfigCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, autovacuumTablesQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	tableCostLimitDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, autovacuumSubsystem, "table_vacuum_cost_limit"),
+		"Cost limit of autovacuum set in the storage parameters of a table",
+		[]string{"datname", "schemaname", "relname"}, server.labels,
+	)
+
+	for rows.Next() {
+		var (
+			datname, schemaname, relname string
+			tableCostLimit               float64
+		)
+		if err := rows.Scan(&datname, &schemaname, &relname, &tableCostLimit); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(tableCostLimitDesc, prometheus.GaugeValue, tableCostLimit, datname, schemaname, relname)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,56 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type AutovacuumConfigSuite struct{}
+
+var _ = Suite(&AutovacuumConfigSuite{})
+
+func (s *AutovacuumConfigSuite) TestAutovacuumConfig(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(autovacuumSettingsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"naptime", "vacuum_cost_limit"}).AddRow(60, 200),
+	)
+
+	metrics := collectMetrics(c, newAutovacuumConfigCollector(), server)
+
+	c.Assert(metrics, HasLen, 2)
+	c.Assert(metrics[0].name, Equals, "pg_autovacuum_naptime_seconds")
+	c.Assert(metrics[0].value, Equals, 60.0)
+	c.Assert(metrics[1].name, Equals, "pg_autovacuum_vacuum_cost_limit")
+	c.Assert(metrics[1].value, Equals, 200.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *AutovacuumConfigSuite) TestAutovacuumTableConfig(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+	server.collectorConfig = newCollectorConfig([]string{"scratch"})
+
+	mock.ExpectQuery(autovacuumTablesQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "schemaname", "relname", "vacuum_cost_limit"}).
+			AddRow("postgres", "public", "events", 2000).
+			AddRow("scratch", "public", "events", 1000),
+	)
+
+	metrics := collectMetrics(c, newAutovacuumTableConfigCollector(), server)
+
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].name, Equals, "pg_autovacuum_table_vacuum_cost_limit")
+	c.Assert(metrics[0].value, Equals, 2000.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":     "test:5432",
+		"datname":    "postgres",
+		"schemaname": "public",
+		"relname":    "events",
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
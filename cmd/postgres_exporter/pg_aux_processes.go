@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+func init() {
+	registerCollector("aux_processes", defaultEnabled, masterOnly, newAuxProcessesCollector)
+}
+
+// auxProcesses are the backend types of the auxiliary processes reported by
+// the collector. Some of them don't run on a standby, or when disabled by the
+// configuration, e.g. the autovacuum launcher with autovacuum = off.
+var auxProcesses = []string{
+	"walwriter",
+	"checkpointer",
+	"background writer",
+	"autovacuum launcher",
+	"logical replication launcher",
+}
+
+// backend_type was added in PostgreSQL 10.
+const auxProcessesQuery = `SELECT DISTINCT backend_type FROM pg_stat_activity WHERE backend_type IS NOT NULL`
+
+type auxProcessesCollector struct{}
+
+func newAuxProcessesCollector() Collector {
+	return &auxProcessesCollector{}
+}
+
+// Update implements Collector.
+func (c *auxProcessesCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if server.lastMapVersion.LT(semver.MustParse("10.0.0")) {
+		log.Debugf("Skipping auxiliary processes on %q: PostgreSQL 10 or newer is required", server)
+		return nil
+	}
+
+	rows, err := server.db.QueryContext(ctx, auxProcessesQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	running := make(map[string]bool)
+	for rows.Next() {
+		var backendType string
+		if err := rows.Scan(&backendType); err != nil {
+			return err
+		}
+		running[backendType] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "aux_process", "up"),
+		"Whether the auxiliary process is running (1 for yes, 0 for no)", []string{"process"}, server.labels,
+	)
+	for _, process := range auxProcesses {
+		up := 0.0
+		if running[process] {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, up, process)
+	}
+	return nil
+}
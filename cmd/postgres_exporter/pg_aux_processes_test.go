@@ -0,0 +1,51 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type AuxProcessesSuite struct{}
+
+var _ = Suite(&AuxProcessesSuite{})
+
+func (s *AuxProcessesSuite) TestAuxProcesses(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(auxProcessesQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"backend_type"}).
+			AddRow("client backend").
+			AddRow("walwriter").
+			AddRow("checkpointer").
+			AddRow("background writer").
+			AddRow("logical replication launcher"),
+	)
+
+	up := make(map[string]float64)
+	for _, m := range collectMetrics(c, newAuxProcessesCollector(), server) {
+		c.Assert(m.name, Equals, "pg_aux_process_up")
+		up[m.labels["process"]] = m.value
+	}
+
+	// Other backend types are ignored, missing processes are reported down.
+	c.Assert(up, DeepEquals, map[string]float64{
+		"walwriter":                    1,
+		"checkpointer":                 1,
+		"background writer":            1,
+		"autovacuum launcher":          0,
+		"logical replication launcher": 1,
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *AuxProcessesSuite) TestAuxProcessesBeforePG10(c *C) {
+	server, mock := newMockServer(c, "9.6.0")
+	defer server.db.Close()
+
+	c.Assert(collectMetrics(c, newAuxProcessesCollector(), server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
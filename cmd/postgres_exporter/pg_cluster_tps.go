@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("cluster_tps", defaultEnabled, masterOnly, newClusterTPSCollector)
+}
+
+// The per-database counters are exported by pg_stat_database, this only
+// sums them up to a single throughput number.
+const clusterTPSQuery = `
+SELECT COALESCE(sum(xact_commit + xact_rollback), 0)::float AS xacts
+FROM pg_stat_database
+`
+
+type clusterTPSCollector struct {
+	mtx sync.Mutex
+	// Transactions seen by the previous scrape, and when.
+	lastXacts float64
+	lastAt    time.Time
+
+	now func() time.Time
+}
+
+func newClusterTPSCollector() Collector {
+	return &clusterTPSCollector{now: time.Now}
+}
+
+// Update implements Collector.
+func (c *clusterTPSCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	var xacts float64
+	if err := server.db.QueryRowContext(ctx, clusterTPSQuery).Scan(&xacts); err != nil {
+		return err
+	}
+
+	if tps, ok := c.observe(xacts); ok {
+		ch <- prometheus.MustNewConstMetric(
+			newDesc("cluster", "tps", "Transactions committed or rolled back per second in all databases since the previous scrape", server.labels),
+			prometheus.GaugeValue, tps,
+		)
+	}
+	return nil
+}
+
+// observe records the transactions of a scrape and returns the rate at which
+// they grew since the previous scrape. There is no rate on the first scrape,
+// or when the counters went backwards because the statistics were reset.
+func (c *clusterTPSCollector) observe(xacts float64) (float64, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.now()
+	lastXacts, lastAt := c.lastXacts, c.lastAt
+	c.lastXacts, c.lastAt = xacts, now
+
+	elapsed := now.Sub(lastAt).Seconds()
+	if lastAt.IsZero() || xacts < lastXacts || elapsed <= 0 {
+		return 0, false
+	}
+	return (xacts - lastXacts) / elapsed, true
+}
@@ -0,0 +1,43 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type ClusterTPSSuite struct{}
+
+var _ = Suite(&ClusterTPSSuite{})
+
+func (s *ClusterTPSSuite) TestClusterTPS(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	for _, xacts := range []float64{10000, 13000, 500} {
+		mock.ExpectQuery(clusterTPSQuery).WillReturnRows(sqlmock.NewRows([]string{"xacts"}).AddRow(xacts))
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	collector := newClusterTPSCollector().(*clusterTPSCollector)
+	collector.now = func() time.Time { return now }
+
+	// The first scrape only sets the baseline.
+	c.Assert(collectMetrics(c, collector, server), HasLen, 0)
+
+	now = now.Add(30 * time.Second)
+	metrics := collectMetrics(c, collector, server)
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].name, Equals, "pg_cluster_tps")
+	c.Assert(metrics[0].value, Equals, 100.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{"server": "test:5432"})
+
+	// The statistics were reset.
+	now = now.Add(30 * time.Second)
+	c.Assert(collectMetrics(c, collector, server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("database", defaultEnabled, masterOnly, newDatabaseCollector)
+}
+
+const databaseSubsystem = "database"
+
+// The size of a database can only be queried with the CONNECT privilege on it,
+// it is NULL otherwise.
+const databaseQuery = `
+SELECT
+	datname,
+	datistemplate,
+	age(datfrozenxid) AS frozen_xid_age,
+	CASE WHEN has_database_privilege(datname, 'CONNECT') THEN pg_database_size(datname)::float END AS size_bytes,
+	datconnlimit,
+	datallowconn,
+	%s
+FROM pg_database
+`
+
+// mxid_age() was added in PostgreSQL 9.5.
+const (
+	databaseMultixactColumn      = "mxid_age(datminmxid) AS min_multixact_age"
+	databaseMultixactColumnPre95 = "NULL::integer AS min_multixact_age"
+)
+
+// The sizes were reported by the pg_database query of the example
+// queries.yaml, as pg_database_size. They aren't reported twice if a custom
+// query still does.
+const databaseSizeUserQuery = "pg_database"
+
+var databaseSizeUserColumns = []string{"size", "size_bytes"}
+
+type databaseCollector struct{}
+
+func newDatabaseCollector() Collector {
+	return &databaseCollector{}
+}
+
+// Update implements Collector.
+func (c *databaseCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	column := databaseMultixactColumnPre95
+	if server.lastMapVersion.GE(semver.MustParse("9.5.0")) {
+		column = databaseMultixactColumn
+	}
+
+	rows, err := server.db.QueryContext(ctx, fmt.Sprintf(databaseQuery, column))
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	frozenXIDAgeDesc := databaseDesc(server, "frozen_xid_age", "Age of the oldest unfrozen transaction ID in the database, in transactions")
+	minMultixactAgeDesc := databaseDesc(server, "min_multixact_age", "Age of the oldest multixact ID in the database, in multixacts")
+	sizeDesc := databaseDesc(server, "size_bytes", "Disk space used by the database, in bytes")
+	connectionLimitDesc := databaseDesc(server, "connection_limit", "Maximum number of concurrent connections to the database, -1 means no limit")
+	allowedDesc := databaseDesc(server, "allowed", "Whether connections to the database are allowed (1 for yes, 0 for no)")
+
+	reportSize := !hasUserSizeQuery(server)
+
+	for rows.Next() {
+		var (
+			datname         string
+			isTemplate      bool
+			frozenXIDAge    float64
+			size            sql.NullFloat64
+			connectionLimit float64
+			allowConn       bool
+			minMultixactAge sql.NullFloat64
+		)
+		if err := rows.Scan(&datname, &isTemplate, &frozenXIDAge, &size, &connectionLimit, &allowConn, &minMultixactAge); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		// Templates are included, a template which isn't vacuumed wraps around too.
+		ch <- prometheus.MustNewConstMetric(frozenXIDAgeDesc, prometheus.GaugeValue, frozenXIDAge, datname)
+		if minMultixactAge.Valid {
+			ch <- prometheus.MustNewConstMetric(minMultixactAgeDesc, prometheus.GaugeValue, minMultixactAge.Float64, datname)
+		}
+		if isTemplate {
+			continue
+		}
+
+		if size.Valid && reportSize {
+			ch <- prometheus.MustNewConstMetric(sizeDesc, prometheus.GaugeValue, size.Float64, datname)
+		}
+		ch <- prometheus.MustNewConstMetric(connectionLimitDesc, prometheus.GaugeValue, connectionLimit, datname)
+		allowed := 0.0
+		if allowConn {
+			allowed = 1
+		}
+		ch <- prometheus.MustNewConstMetric(allowedDesc, prometheus.GaugeValue, allowed, datname)
+	}
+
+	return rows.Err()
+}
+
+// hasUserSizeQuery returns whether a custom query of the server reports the
+// sizes of the databases.
+func hasUserSizeQuery(server *Server) bool {
+	server.mappingMtx.RLock()
+	defer server.mappingMtx.RUnlock()
+
+	mapping, ok := server.metricMap[databaseSizeUserQuery]
+	if !ok {
+		return false
+	}
+	for _, column := range databaseSizeUserColumns {
+		if _, ok := mapping.columnMappings[column]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func databaseDesc(server *Server, name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, databaseSubsystem, name),
+		help, []string{"datname"}, server.labels,
+	)
+}
@@ -0,0 +1,131 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type DatabaseSuite struct{}
+
+var _ = Suite(&DatabaseSuite{})
+
+var databaseColumns = []string{"datname", "datistemplate", "frozen_xid_age", "size_bytes", "datconnlimit", "datallowconn", "min_multixact_age"}
+
+func (s *DatabaseSuite) TestDatabaseFrozenXIDAge(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(fmt.Sprintf(databaseQuery, databaseMultixactColumn)).WillReturnRows(
+		sqlmock.NewRows(databaseColumns).
+			AddRow("postgres", false, 1200, 8000000, -1, true, 10).
+			AddRow("orders", false, 150000000, 5000000000, 100, true, 20).
+			AddRow("template1", true, 2000000000, 7000000, -1, true, 30),
+	)
+
+	var metrics []metricResult
+	minMultixactAges := make(map[string]float64)
+	for _, m := range collectMetrics(c, newDatabaseCollector(), server) {
+		switch m.name {
+		case "pg_database_frozen_xid_age":
+			metrics = append(metrics, m)
+		case "pg_database_min_multixact_age":
+			minMultixactAges[m.labels["datname"]] = m.value
+		}
+	}
+	c.Assert(minMultixactAges, DeepEquals, map[string]float64{"postgres": 10, "orders": 20, "template1": 30})
+
+	c.Assert(metrics, HasLen, 3)
+	expected := map[string]float64{
+		"postgres":  1200,
+		"orders":    150000000,
+		"template1": 2000000000,
+	}
+	for _, m := range metrics {
+		c.Assert(m.value, Equals, expected[m.labels["datname"]])
+		c.Assert(m.labels["server"], Equals, "test:5432")
+	}
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *DatabaseSuite) TestDatabaseSize(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+	server.collectorConfig = newCollectorConfig([]string{"scratch"})
+
+	mock.ExpectQuery(fmt.Sprintf(databaseQuery, databaseMultixactColumn)).WillReturnRows(
+		sqlmock.NewRows(databaseColumns).
+			AddRow("orders", false, 1200, 5000000000, 100, true, 10).
+			AddRow("restricted", false, 1200, nil, -1, false, 10).
+			AddRow("scratch", false, 1200, 1000, -1, true, 10).
+			AddRow("template1", true, 1200, 7000000, -1, true, 10),
+	)
+
+	values := make(map[string]map[string]float64)
+	for _, m := range collectMetrics(c, newDatabaseCollector(), server) {
+		if m.name == "pg_database_frozen_xid_age" || m.name == "pg_database_min_multixact_age" {
+			continue
+		}
+		if values[m.labels["datname"]] == nil {
+			values[m.labels["datname"]] = make(map[string]float64)
+		}
+		values[m.labels["datname"]][m.name] = m.value
+	}
+
+	// Templates and excluded databases are skipped, and the size of a
+	// database without the CONNECT privilege is unknown.
+	c.Assert(values, DeepEquals, map[string]map[string]float64{
+		"orders": {
+			"pg_database_size_bytes":       5000000000,
+			"pg_database_connection_limit": 100,
+			"pg_database_allowed":          1,
+		},
+		"restricted": {
+			"pg_database_connection_limit": -1,
+			"pg_database_allowed":          0,
+		},
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *DatabaseSuite) TestDatabaseMinMultixactAge(c *C) {
+	server, mock := newMockServer(c, "9.4.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(fmt.Sprintf(databaseQuery, databaseMultixactColumnPre95)).WillReturnRows(
+		sqlmock.NewRows(databaseColumns).
+			AddRow("postgres", false, 1200, 8000000, -1, true, nil),
+	)
+
+	// mxid_age() isn't available before PostgreSQL 9.5.
+	for _, m := range collectMetrics(c, newDatabaseCollector(), server) {
+		c.Assert(m.name, Not(Equals), "pg_database_min_multixact_age")
+	}
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *DatabaseSuite) TestDatabaseSizeUserQuery(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	// The pg_database query of the former example queries.yaml.
+	server.metricMap = map[string]MetricMapNamespace{
+		"pg_database": {columnMappings: map[string]MetricMap{"datname": {}, "size": {}}},
+	}
+
+	mock.ExpectQuery(fmt.Sprintf(databaseQuery, databaseMultixactColumn)).WillReturnRows(
+		sqlmock.NewRows(databaseColumns).
+			AddRow("orders", false, 1200, 5000000000, 100, true, 10),
+	)
+
+	metrics := collectMetrics(c, newDatabaseCollector(), server)
+	c.Assert(metrics, HasLen, 4)
+	for _, m := range metrics {
+		c.Assert(m.name, Not(Equals), "pg_database_size_bytes")
+	}
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
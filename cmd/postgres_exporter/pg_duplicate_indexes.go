@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("duplicate_indexes", defaultDisabled, everyDatabase, newDuplicateIndexesCollector)
+}
+
+// The columns of the indexes are compared in Go. Expression and partial
+// indexes are left out, their columns alone don't tell what they cover.
+// Only the key columns are selected, the INCLUDE columns of PostgreSQL 11
+// can't be searched or sorted on. indclass and indcollation only have
+// entries for the key columns.
+var (
+	duplicateIndexesQuery        = duplicateIndexesSelect("x.indnkeyatts")
+	duplicateIndexesQueryPrePG11 = duplicateIndexesSelect("x.indnatts")
+)
+
+func duplicateIndexesSelect(keyColumns string) string {
+	return `
+SELECT
+	current_database() AS datname,
+	n.nspname AS schemaname,
+	t.relname AS relname,
+	i.relname AS indexrelname,
+	am.amname,
+	array_to_string((string_to_array(x.indkey::text, ' '))[1:` + keyColumns + `], ' ') AS indkey,
+	x.indclass::text AS indclass,
+	x.indcollation::text AS indcollation,
+	x.indisunique
+FROM pg_index x
+	JOIN pg_class i ON i.oid = x.indexrelid
+	JOIN pg_class t ON t.oid = x.indrelid
+	JOIN pg_namespace n ON n.oid = t.relnamespace
+	JOIN pg_am am ON am.oid = i.relam
+WHERE x.indisvalid
+	AND x.indexprs IS NULL
+	AND x.indpred IS NULL
+	AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+	AND n.nspname !~ '^pg_toast'
+`
+}
+
+// duplicateIndexesTable identifies a table.
+type duplicateIndexesTable struct {
+	datname, schemaname, relname string
+}
+
+// duplicateIndexesIndex is an index of a table, with the attribute numbers,
+// operator classes and collations of its key columns.
+type duplicateIndexesIndex struct {
+	name    string
+	method  string
+	columns []string
+	unique  bool
+}
+
+// redundantTo returns whether the index is made redundant by the other one:
+// both use the same access method, and the columns of the index are the
+// first columns of the other one, with the same operator classes and
+// collations. Unique indexes are never redundant, they
+// enforce a constraint. Of two equal non-unique indexes, the one whose name
+// sorts last is redundant.
+func (i duplicateIndexesIndex) redundantTo(other duplicateIndexesIndex) bool {
+	if i.unique || i.name == other.name || i.method != other.method || len(i.columns) > len(other.columns) {
+		return false
+	}
+	for n, column := range i.columns {
+		if other.columns[n] != column {
+			return false
+		}
+	}
+	return len(i.columns) < len(other.columns) || other.unique || other.name < i.name
+}
+
+// duplicateIndexesColumns returns the key columns of an index as
+// attnum/opclass/collation, from the space-separated vectors of pg_index.
+// The columns are compared as a whole.
+func duplicateIndexesColumns(indkey, indclass, indcollation string) []string {
+	keys := strings.Fields(indkey)
+	classes := strings.Fields(indclass)
+	collations := strings.Fields(indcollation)
+
+	columns := make([]string, len(keys))
+	for n, key := range keys {
+		columns[n] = key
+		if n < len(classes) {
+			columns[n] += "/" + classes[n]
+		}
+		if n < len(collations) {
+			columns[n] += "/" + collations[n]
+		}
+	}
+	return columns
+}
+
+type duplicateIndexesCollector struct{}
+
+func newDuplicateIndexesCollector() Collector {
+	return &duplicateIndexesCollector{}
+}
+
+// Update implements Collector.
+func (c *duplicateIndexesCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	query := duplicateIndexesQuery
+	if server.lastMapVersion.LT(semver.MustParse("11.0.0")) {
+		query = duplicateIndexesQueryPrePG11
+	}
+	rows, err := server.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	var tables []duplicateIndexesTable
+	indexes := make(map[duplicateIndexesTable][]duplicateIndexesIndex)
+	for rows.Next() {
+		var (
+			table                          duplicateIndexesTable
+			index                          duplicateIndexesIndex
+			indkey, indclass, indcollation string
+		)
+		if err := rows.Scan(&table.datname, &table.schemaname, &table.relname, &index.name, &index.method, &indkey, &indclass, &indcollation, &index.unique); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(table.datname) {
+			continue
+		}
+
+		index.columns = duplicateIndexesColumns(indkey, indclass, indcollation)
+		if _, ok := indexes[table]; !ok {
+			tables = append(tables, table)
+		}
+		indexes[table] = append(indexes[table], index)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "duplicate_index"),
+		"Index is redundant, its key columns are the first key columns of another index of the table with the same access method, operator classes and collations",
+		[]string{"datname", "schemaname", "relname", "indexrelname"}, server.labels,
+	)
+	for _, table := range tables {
+		for _, index := range indexes[table] {
+			for _, other := range indexes[table] {
+				if index.redundantTo(other) {
+					ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, table.datname, table.schemaname, table.relname, index.name)
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
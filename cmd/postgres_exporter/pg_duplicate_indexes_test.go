@@ -0,0 +1,97 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type DuplicateIndexesSuite struct{}
+
+var duplicateIndexesColumnNames = []string{"datname", "schemaname", "relname", "indexrelname", "amname", "indkey", "indclass", "indcollation", "indisunique"}
+
+var _ = Suite(&DuplicateIndexesSuite{})
+
+func (s *DuplicateIndexesSuite) TestDuplicateIndexes(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(duplicateIndexesQuery).WillReturnRows(
+		sqlmock.NewRows(duplicateIndexesColumnNames).
+			AddRow("postgres", "public", "orders", "orders_pkey", "btree", "1", "1978", "0", true).
+			AddRow("postgres", "public", "orders", "orders_customer_id_idx", "btree", "2", "1978", "0", false).
+			AddRow("postgres", "public", "orders", "orders_customer_id_created_at_idx", "btree", "2 3", "1978 3128", "0 0", false).
+			AddRow("postgres", "public", "orders", "orders_created_at_customer_id_idx", "btree", "3 2", "3128 1978", "0 0", false).
+			AddRow("postgres", "public", "orders", "orders_customer_id_hash_idx", "hash", "2", "1977", "0", false).
+			AddRow("postgres", "public", "customers", "customers_pkey", "btree", "1", "1978", "0", true).
+			AddRow("postgres", "public", "customers", "customers_id_idx", "btree", "1", "1978", "0", false),
+	)
+
+	metrics := collectMetrics(c, newDuplicateIndexesCollector(), server)
+
+	// The prefix of another index, and the copy of the primary key, are
+	// redundant. The index with the columns in another order, and the index
+	// with another access method, are not.
+	c.Assert(metrics, HasLen, 2)
+	c.Assert(metrics[0].name, Equals, "pg_duplicate_index")
+	c.Assert(metrics[0].value, Equals, 1.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":       "test:5432",
+		"datname":      "postgres",
+		"schemaname":   "public",
+		"relname":      "orders",
+		"indexrelname": "orders_customer_id_idx",
+	})
+	c.Assert(metrics[1].labels["indexrelname"], Equals, "customers_id_idx")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *DuplicateIndexesSuite) TestDuplicateIndexesKeyColumns(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	// The query selects the key columns only: (customer_id) INCLUDE
+	// (status, total) is returned as column 2 alone.
+	mock.ExpectQuery(duplicateIndexesQuery).WillReturnRows(
+		sqlmock.NewRows(duplicateIndexesColumnNames).
+			AddRow("postgres", "public", "orders", "orders_customer_id_status_idx", "btree", "2 4", "1978 1978", "0 0", false).
+			AddRow("postgres", "public", "orders", "orders_customer_id_include_idx", "btree", "2", "1978", "0", false).
+			AddRow("postgres", "public", "orders", "orders_name_idx", "btree", "5", "3126", "100", false).
+			AddRow("postgres", "public", "orders", "orders_name_c_idx", "btree", "5", "3126", "950", false).
+			AddRow("postgres", "public", "orders", "orders_name_pattern_idx", "btree", "5", "10049", "100", false),
+	)
+
+	// The covering index is redundant to the one with status as a key
+	// column, not the other way around. The indexes with another collation
+	// or operator class are not redundant.
+	metrics := collectMetrics(c, newDuplicateIndexesCollector(), server)
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].labels["indexrelname"], Equals, "orders_customer_id_include_idx")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *DuplicateIndexesSuite) TestDuplicateIndexesPrePG11(c *C) {
+	server, mock := newMockServer(c, "10.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(duplicateIndexesQueryPrePG11).WillReturnRows(sqlmock.NewRows(duplicateIndexesColumnNames))
+	c.Assert(collectMetrics(c, newDuplicateIndexesCollector(), server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *DuplicateIndexesSuite) TestDuplicateIndexesColumns(c *C) {
+	c.Assert(duplicateIndexesColumns("2 3", "1978 3128", "0 100"), DeepEquals, []string{"2/1978/0", "3/3128/100"})
+	c.Assert(duplicateIndexesColumns("", "", ""), HasLen, 0)
+}
+
+func (s *DuplicateIndexesSuite) TestEqualIndexes(c *C) {
+	a := duplicateIndexesIndex{name: "orders_a_idx", method: "btree", columns: []string{"2", "3"}}
+	b := duplicateIndexesIndex{name: "orders_b_idx", method: "btree", columns: []string{"2", "3"}}
+
+	// Only one of two equal indexes is redundant.
+	c.Assert(a.redundantTo(b), Equals, false)
+	c.Assert(b.redundantTo(a), Equals, true)
+	c.Assert(a.redundantTo(a), Equals, false)
+}
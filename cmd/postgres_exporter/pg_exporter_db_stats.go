@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("db_stats", defaultEnabled, everyDatabase, newDBStatsCollector)
+}
+
+// dbStatsCollector reports the statistics of the connection pool of the
+// exporter to the database, e.g. to see whether --db.max-open-conns is too
+// low. Each discovered database has its own pool, so the metrics are labelled
+// by datname.
+type dbStatsCollector struct{}
+
+func newDBStatsCollector() Collector {
+	return &dbStatsCollector{}
+}
+
+// Update implements Collector.
+func (c *dbStatsCollector) Update(_ context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	stats := server.db.Stats()
+
+	for _, m := range []struct {
+		name, help string
+		valueType  prometheus.ValueType
+		value      float64
+	}{
+		{"db_max_open_connections", "Maximum number of open connections to the database", prometheus.GaugeValue, float64(stats.MaxOpenConnections)},
+		{"db_open_connections", "Number of established connections to the database, both in use and idle", prometheus.GaugeValue, float64(stats.OpenConnections)},
+		{"db_in_use", "Number of connections currently in use", prometheus.GaugeValue, float64(stats.InUse)},
+		{"db_idle", "Number of idle connections", prometheus.GaugeValue, float64(stats.Idle)},
+		{"db_wait_count_total", "Total number of connections waited for", prometheus.CounterValue, float64(stats.WaitCount)},
+		{"db_wait_duration_seconds_total", "Total time blocked waiting for a new connection, in seconds", prometheus.CounterValue, stats.WaitDuration.Seconds()},
+	} {
+		desc := prometheus.NewDesc(prometheus.BuildFQName(namespace, exporter, m.name), m.help, []string{"datname"}, server.labels)
+		ch <- prometheus.MustNewConstMetric(desc, m.valueType, m.value, server.datname)
+	}
+	return nil
+}
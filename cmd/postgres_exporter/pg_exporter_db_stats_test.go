@@ -0,0 +1,36 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type DBStatsSuite struct{}
+
+var _ = Suite(&DBStatsSuite{})
+
+func (s *DBStatsSuite) TestDBStats(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+	server.datname = "postgres"
+	server.db.SetMaxOpenConns(1)
+	c.Assert(server.db.Ping(), IsNil)
+
+	values := make(map[string]float64)
+	for _, m := range collectMetrics(c, newDBStatsCollector(), server) {
+		c.Assert(m.labels, DeepEquals, map[string]string{"server": "test:5432", "datname": "postgres"})
+		values[m.name] = m.value
+	}
+
+	c.Assert(values, DeepEquals, map[string]float64{
+		"pg_exporter_db_max_open_connections":        1,
+		"pg_exporter_db_open_connections":            1,
+		"pg_exporter_db_in_use":                      0,
+		"pg_exporter_db_idle":                        1,
+		"pg_exporter_db_wait_count_total":            0,
+		"pg_exporter_db_wait_duration_seconds_total": 0,
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	registerCollector("invalid_indexes", defaultEnabled, everyDatabase, newInvalidIndexesCollector)
+}
+
+var invalidIndexesExcludeSchemas = kingpin.Flag("collector.invalid_indexes.exclude-schemas", "A comma-separated list of schemas to skip in the invalid_indexes collector.").Default("").Envar("PG_EXPORTER_INVALID_INDEXES_EXCLUDE_SCHEMAS").String()
+
+// Indexes are left invalid by failed CREATE INDEX CONCURRENTLY or
+// REINDEX CONCURRENTLY runs. They are still maintained on writes but never
+// used by the planner.
+const invalidIndexesQuery = `
+SELECT
+	current_database() AS datname,
+	n.nspname AS schemaname,
+	t.relname AS relname,
+	i.relname AS indexrelname
+FROM pg_index x
+	JOIN pg_class i ON i.oid = x.indexrelid
+	JOIN pg_class t ON t.oid = x.indrelid
+	JOIN pg_namespace n ON n.oid = t.relnamespace
+WHERE NOT x.indisvalid
+`
+
+type invalidIndexesCollector struct {
+	excludeSchemas []string
+}
+
+func newInvalidIndexesCollector() Collector {
+	var excludeSchemas []string
+	for _, schema := range strings.Split(*invalidIndexesExcludeSchemas, ",") {
+		if schema = strings.TrimSpace(schema); schema != "" {
+			excludeSchemas = append(excludeSchemas, schema)
+		}
+	}
+	return &invalidIndexesCollector{excludeSchemas: excludeSchemas}
+}
+
+// Update implements Collector.
+func (c *invalidIndexesCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, invalidIndexesQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "invalid_indexes"),
+		"Index is invalid and not used by the planner, e.g. after a failed CREATE INDEX CONCURRENTLY",
+		[]string{"datname", "schemaname", "relname", "indexrelname"}, server.labels,
+	)
+
+	for rows.Next() {
+		var datname, schemaname, relname, indexrelname string
+		if err := rows.Scan(&datname, &schemaname, &relname, &indexrelname); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) || contains(c.excludeSchemas, schemaname) {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, datname, schemaname, relname, indexrelname)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,39 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type InvalidIndexesSuite struct{}
+
+var _ = Suite(&InvalidIndexesSuite{})
+
+func (s *InvalidIndexesSuite) TestInvalidIndexes(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(invalidIndexesQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "schemaname", "relname", "indexrelname"}).
+			AddRow("postgres", "public", "orders", "orders_customer_id_idx").
+			AddRow("postgres", "audit", "events", "events_created_at_idx"),
+	)
+
+	collector := &invalidIndexesCollector{excludeSchemas: []string{"audit"}}
+	metrics := collectMetrics(c, collector, server)
+
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].name, Equals, "pg_invalid_indexes")
+	c.Assert(metrics[0].value, Equals, 1.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":       "test:5432",
+		"datname":      "postgres",
+		"schemaname":   "public",
+		"relname":      "orders",
+		"indexrelname": "orders_customer_id_idx",
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
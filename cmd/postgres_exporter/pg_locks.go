@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerDefaultMetricsCollector("locks", defaultEnabled, masterOnly, newLocksCollector)
+}
+
+const locksSubsystem = "locks"
+
+var locksLabels = []string{"datname", "mode", "locktype"}
+
+// Locks on objects which don't belong to a database, e.g. transaction IDs,
+// are reported with an empty datname. Modes are lowercased for compatibility
+// with the former pg_locks column mapping. Like it, every mode of every
+// database is reported for the relation locks, with a zero count if there are
+// none, so that the series don't disappear while nothing is locked.
+const locksQuery = `
+WITH locks AS (
+	SELECT
+		COALESCE(pg_database.datname, '') AS datname,
+		lower(pg_locks.mode) AS mode,
+		pg_locks.locktype,
+		count(*) AS count,
+		sum(CASE WHEN pg_locks.granted THEN 0 ELSE 1 END) AS not_granted
+	FROM pg_locks
+	LEFT JOIN pg_database ON pg_database.oid = pg_locks.database
+	GROUP BY 1, 2, 3
+)
+SELECT datname, mode, locktype, count, not_granted
+FROM locks
+UNION ALL
+SELECT pg_database.datname, modes.mode, 'relation', 0, 0
+FROM
+	(
+	  VALUES ('accesssharelock'),
+	         ('rowsharelock'),
+	         ('rowexclusivelock'),
+	         ('shareupdateexclusivelock'),
+	         ('sharelock'),
+	         ('sharerowexclusivelock'),
+	         ('exclusivelock'),
+	         ('accessexclusivelock')
+	) AS modes(mode) CROSS JOIN pg_database
+WHERE NOT EXISTS (
+	SELECT 1 FROM locks
+	WHERE locks.datname = pg_database.datname AND locks.mode = modes.mode AND locks.locktype = 'relation'
+)
+`
+
+type locksCollector struct{}
+
+func newLocksCollector() Collector {
+	return &locksCollector{}
+}
+
+// Update implements Collector.
+func (c *locksCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, locksQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	countDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, locksSubsystem, "count"),
+		"Number of locks", locksLabels, server.labels,
+	)
+	notGrantedDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, locksSubsystem, "not_granted"),
+		"Number of locks which are waited for", locksLabels, server.labels,
+	)
+
+	for rows.Next() {
+		var (
+			datname, mode, locktype string
+			count, notGranted       sql.NullFloat64
+		)
+		if err := rows.Scan(&datname, &mode, &locktype, &count, &notGranted); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(countDesc, prometheus.GaugeValue, count.Float64, datname, mode, locktype)
+		ch <- prometheus.MustNewConstMetric(notGrantedDesc, prometheus.GaugeValue, notGranted.Float64, datname, mode, locktype)
+	}
+	return rows.Err()
+}
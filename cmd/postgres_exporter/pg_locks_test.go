@@ -0,0 +1,64 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type LocksSuite struct{}
+
+var _ = Suite(&LocksSuite{})
+
+func (s *LocksSuite) TestLocks(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(locksQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "mode", "locktype", "count", "not_granted"}).
+			AddRow("postgres", "rowexclusivelock", "relation", 3, 1).
+			AddRow("", "exclusivelock", "transactionid", 2, 0).
+			AddRow("postgres", "accessexclusivelock", "relation", 0, 0),
+	)
+
+	metrics := collectMetrics(c, newLocksCollector(), server)
+
+	c.Assert(metrics, HasLen, 6)
+	c.Assert(metrics[0].name, Equals, "pg_locks_count")
+	c.Assert(metrics[0].value, Equals, 3.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":   "test:5432",
+		"datname":  "postgres",
+		"mode":     "rowexclusivelock",
+		"locktype": "relation",
+	})
+	c.Assert(metrics[1].name, Equals, "pg_locks_not_granted")
+	c.Assert(metrics[1].value, Equals, 1.0)
+	c.Assert(metrics[2].labels["datname"], Equals, "")
+	c.Assert(metrics[3].value, Equals, 0.0)
+
+	// The modes without locks are reported with a zero count.
+	c.Assert(metrics[4].labels["mode"], Equals, "accessexclusivelock")
+	c.Assert(metrics[4].value, Equals, 0.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *LocksSuite) TestLocksExcludedDatabase(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+	server.collectorConfig = newCollectorConfig([]string{"scratch"})
+
+	mock.ExpectQuery(locksQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "mode", "locktype", "count", "not_granted"}).
+			AddRow("scratch", "rowexclusivelock", "relation", 3, 1).
+			AddRow("", "exclusivelock", "transactionid", 2, 0),
+	)
+
+	metrics := collectMetrics(c, newLocksCollector(), server)
+
+	c.Assert(metrics, HasLen, 2)
+	c.Assert(metrics[0].labels["datname"], Equals, "")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	registerCollector("reindex_candidates", defaultDisabled, everyDatabase, newReindexCandidatesCollector)
+}
+
+var reindexCandidatesBloatRatio = kingpin.Flag("collector.reindex_candidates.bloat-ratio", "Estimated fraction of the pages of a B-tree index which are bloat above which it should be rebuilt.").Default("0.5").Envar("PG_EXPORTER_REINDEX_CANDIDATES_BLOAT_RATIO").Float64()
+
+// The bloat of B-tree indexes is estimated from the number of pages their
+// tuples would fill, using the average width of the key columns from
+// pg_stats: a tuple takes an 8 bytes header and a 4 bytes line pointer, and
+// a page holds 40 bytes of page header and B-tree special space, and is
+// filled up to the fillfactor, 90 by default. The metapage is added to the
+// estimate. There is no estimate for the indexes on expressions, or without
+// statistics on all their columns, or for the invalid ones.
+const reindexCandidatesQuery = `
+WITH indexes AS (
+	SELECT
+		n.nspname AS schemaname,
+		t.relname,
+		i.relname AS indexrelname,
+		x.indisvalid,
+		i.relpages,
+		i.reltuples,
+		COALESCE(substring(array_to_string(i.reloptions, ' ') FROM 'fillfactor=([0-9]+)')::int, 90) AS fillfactor,
+		am.amname = 'btree' AND 0 <> ALL (x.indkey) AS estimable,
+		(
+			SELECT CASE WHEN count(s.avg_width) = x.indnatts THEN sum(s.avg_width) END
+			FROM pg_attribute a
+				JOIN pg_stats s ON s.schemaname = n.nspname AND s.tablename = t.relname AND s.attname = a.attname
+			WHERE a.attrelid = t.oid AND a.attnum = ANY (x.indkey)
+		) AS key_width
+	FROM pg_index x
+		JOIN pg_class i ON i.oid = x.indexrelid
+		JOIN pg_class t ON t.oid = x.indrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		JOIN pg_am am ON am.oid = i.relam
+	WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')
+)
+SELECT
+	current_database() AS datname,
+	schemaname,
+	relname,
+	indexrelname,
+	NOT indisvalid AS invalid,
+	CASE WHEN indisvalid AND estimable AND key_width IS NOT NULL AND relpages > 1 THEN
+		GREATEST(0, 1 - (1 + ceil(reltuples * (12 + 8 * ceil(key_width / 8.0)) / ((current_setting('block_size')::int - 40) * fillfactor / 100.0))) / relpages)
+	END::float AS bloat_ratio
+FROM indexes
+WHERE NOT indisvalid OR (estimable AND key_width IS NOT NULL)
+`
+
+type reindexCandidatesCollector struct {
+	bloatRatio float64
+}
+
+func newReindexCandidatesCollector() Collector {
+	return &reindexCandidatesCollector{
+		bloatRatio: *reindexCandidatesBloatRatio,
+	}
+}
+
+// Update implements Collector.
+func (c *reindexCandidatesCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, reindexCandidatesQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "index", "reindex_candidate"),
+		"Index is invalid or its estimated bloat exceeds --collector.reindex_candidates.bloat-ratio, so it should be rebuilt",
+		[]string{"datname", "schemaname", "relname", "indexrelname"}, server.labels,
+	)
+
+	for rows.Next() {
+		var (
+			datname, schemaname, relname, indexrelname string
+			invalid                                    bool
+			bloatRatio                                 sql.NullFloat64
+		)
+		if err := rows.Scan(&datname, &schemaname, &relname, &indexrelname, &invalid, &bloatRatio); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		if invalid || (bloatRatio.Valid && bloatRatio.Float64 >= c.bloatRatio) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, datname, schemaname, relname, indexrelname)
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,40 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type ReindexCandidatesSuite struct{}
+
+var _ = Suite(&ReindexCandidatesSuite{})
+
+func (s *ReindexCandidatesSuite) TestReindexCandidates(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(reindexCandidatesQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "schemaname", "relname", "indexrelname", "invalid", "bloat_ratio"}).
+			AddRow("app", "public", "orders", "orders_customer_id_idx", true, nil).
+			AddRow("app", "public", "orders", "orders_created_at_idx", false, 0.72).
+			AddRow("app", "public", "orders", "orders_pkey", false, 0.1),
+	)
+
+	metrics := collectMetrics(c, &reindexCandidatesCollector{bloatRatio: 0.5}, server)
+
+	c.Assert(metrics, HasLen, 2)
+	c.Assert(metrics[0].name, Equals, "pg_index_reindex_candidate")
+	c.Assert(metrics[0].value, Equals, 1.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":       "test:5432",
+		"datname":      "app",
+		"schemaname":   "public",
+		"relname":      "orders",
+		"indexrelname": "orders_customer_id_idx",
+	})
+	c.Assert(metrics[1].labels["indexrelname"], Equals, "orders_created_at_idx")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+func init() {
+	registerCollector("replication_slots", defaultEnabled, masterOnly, newReplicationSlotsCollector)
+}
+
+const replicationSlotSubsystem = "replication_slot"
+
+var replicationSlotLabels = []string{"slot_name", "slot_type", "active"}
+
+// The current WAL position can't be queried with pg_current_wal_lsn() during
+// recovery, so on a standby the last received position is used instead.
+const replicationSlotsQuery = `
+SELECT
+	slot_name,
+	slot_type,
+	active,
+	pg_wal_lsn_diff(current.lsn, '0/0')::float AS current_wal_lsn,
+	pg_wal_lsn_diff(confirmed_flush_lsn, '0/0')::float AS confirmed_flush_lsn,
+	pg_wal_lsn_diff(current.lsn, restart_lsn)::float AS retained_bytes,
+	%s
+FROM pg_replication_slots,
+	(SELECT CASE WHEN pg_is_in_recovery() THEN pg_last_wal_receive_lsn() ELSE pg_current_wal_lsn() END AS lsn) AS current
+`
+
+// safe_wal_size and wal_status were added in PostgreSQL 13.
+const (
+	replicationSlotsColumnsPG13 = "safe_wal_size, wal_status"
+	replicationSlotsColumns     = "NULL::bigint AS safe_wal_size, NULL::text AS wal_status"
+)
+
+const replicationSlotsMaxQuery = `SELECT current_setting('max_replication_slots')::float`
+
+type replicationSlotsCollector struct{}
+
+func newReplicationSlotsCollector() Collector {
+	return &replicationSlotsCollector{}
+}
+
+// Update implements Collector.
+func (c *replicationSlotsCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if server.lastMapVersion.LT(semver.MustParse("10.0.0")) {
+		log.Debugf("Skipping replication slots on %q: PostgreSQL 10 or newer is required", server)
+		return nil
+	}
+
+	columns := replicationSlotsColumns
+	if server.lastMapVersion.GE(semver.MustParse("13.0.0")) {
+		columns = replicationSlotsColumnsPG13
+	}
+
+	rows, err := server.db.QueryContext(ctx, fmt.Sprintf(replicationSlotsQuery, columns))
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	var used float64
+	for rows.Next() {
+		used++
+
+		var (
+			slotName, slotType, walStatus                sql.NullString
+			active                                       bool
+			currentLSN, confirmedFlushLSN, retainedBytes sql.NullFloat64
+			safeWALSize                                  sql.NullInt64
+		)
+		if err := rows.Scan(&slotName, &slotType, &active, &currentLSN, &confirmedFlushLSN, &retainedBytes, &safeWALSize, &walStatus); err != nil {
+			return err
+		}
+
+		labels := []string{slotName.String, slotType.String, strconv.FormatBool(active)}
+
+		if currentLSN.Valid {
+			ch <- prometheus.MustNewConstMetric(
+				replicationSlotDesc(server, "current_wal_lsn", "Current WAL position of the server, in bytes"),
+				prometheus.GaugeValue, currentLSN.Float64, labels...)
+		}
+		// Only logical slots have a confirmed flush position.
+		if confirmedFlushLSN.Valid {
+			ch <- prometheus.MustNewConstMetric(
+				replicationSlotDesc(server, "confirmed_flush_lsn", "Position up to which the consumer of the slot has confirmed receiving data, in bytes"),
+				prometheus.GaugeValue, confirmedFlushLSN.Float64, labels...)
+		}
+		// WAL which is still to be decoded and sent to the consumer of a logical slot.
+		if slotType.String == "logical" && currentLSN.Valid && confirmedFlushLSN.Valid {
+			desc := prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "logical_slot", "pending_bytes"),
+				"Amount of WAL not yet confirmed by the consumer of the logical slot, in bytes",
+				[]string{"slot_name"}, server.labels)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, currentLSN.Float64-confirmedFlushLSN.Float64, slotName.String)
+		}
+		// Inactive slots retain WAL too, that's exactly when they grow.
+		if retainedBytes.Valid {
+			ch <- prometheus.MustNewConstMetric(
+				replicationSlotDesc(server, "retained_bytes", "Amount of WAL retained by the slot, in bytes"),
+				prometheus.GaugeValue, retainedBytes.Float64, labels...)
+		}
+		// safe_wal_size is NULL unless max_slot_wal_keep_size is set.
+		if safeWALSize.Valid {
+			ch <- prometheus.MustNewConstMetric(
+				replicationSlotDesc(server, "safe_wal_size_bytes", "Amount of WAL that can be written before the slot is in danger of getting lost"),
+				prometheus.GaugeValue, float64(safeWALSize.Int64), labels...)
+		}
+		if walStatus.Valid {
+			desc := prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, replicationSlotSubsystem, "wal_status"),
+				"Availability of the WAL files claimed by the slot",
+				append(replicationSlotLabels, "wal_status"), server.labels)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, append(labels, walStatus.String)...)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// New standbys and subscribers can't create a slot once all of them are used.
+	var max float64
+	if err := server.db.QueryRowContext(ctx, replicationSlotsMaxQuery).Scan(&max); err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(
+		newDesc("replication_slots", "used", "Number of replication slots", server.labels),
+		prometheus.GaugeValue, used,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		newDesc("replication_slots", "max", "Maximum number of replication slots, from max_replication_slots", server.labels),
+		prometheus.GaugeValue, max,
+	)
+	return nil
+}
+
+func replicationSlotDesc(server *Server, name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, replicationSlotSubsystem, name),
+		help, replicationSlotLabels, server.labels,
+	)
+}
@@ -0,0 +1,84 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type ReplicationSlotsSuite struct{}
+
+var _ = Suite(&ReplicationSlotsSuite{})
+
+var replicationSlotsColumnNames = []string{
+	"slot_name", "slot_type", "active", "current_wal_lsn", "confirmed_flush_lsn", "retained_bytes", "safe_wal_size", "wal_status",
+}
+
+func (s *ReplicationSlotsSuite) TestLogicalSlotPendingBytes(c *C) {
+	server, mock := newMockServer(c, "14.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(fmt.Sprintf(replicationSlotsQuery, replicationSlotsColumnsPG13)).WillReturnRows(
+		sqlmock.NewRows(replicationSlotsColumnNames).
+			AddRow("standby1", "physical", true, 5000.0, nil, 1000.0, nil, "reserved").
+			AddRow("subscriber", "logical", false, 5000.0, 4200.0, 2000.0, nil, "extended"),
+	)
+	mock.ExpectQuery(replicationSlotsMaxQuery).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(10))
+
+	var pending []metricResult
+	for _, m := range collectMetrics(c, newReplicationSlotsCollector(), server) {
+		if m.name == "pg_logical_slot_pending_bytes" {
+			pending = append(pending, m)
+		}
+	}
+
+	c.Assert(pending, HasLen, 1)
+	c.Assert(pending[0].value, Equals, 800.0)
+	c.Assert(pending[0].labels, DeepEquals, map[string]string{"server": "test:5432", "slot_name": "subscriber"})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *ReplicationSlotsSuite) TestInactiveSlotRetainedBytes(c *C) {
+	server, mock := newMockServer(c, "12.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(fmt.Sprintf(replicationSlotsQuery, replicationSlotsColumns)).WillReturnRows(
+		sqlmock.NewRows(replicationSlotsColumnNames).
+			AddRow("standby1", "physical", false, 5000.0, nil, 1000.0, nil, nil),
+	)
+	mock.ExpectQuery(replicationSlotsMaxQuery).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(10))
+
+	metrics := collectMetrics(c, newReplicationSlotsCollector(), server)
+
+	c.Assert(metrics, HasLen, 4)
+	c.Assert(metrics[1].name, Equals, "pg_replication_slot_retained_bytes")
+	c.Assert(metrics[1].value, Equals, 1000.0)
+	c.Assert(metrics[1].labels["active"], Equals, "false")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *ReplicationSlotsSuite) TestSlotsUsedAndMax(c *C) {
+	server, mock := newMockServer(c, "14.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(fmt.Sprintf(replicationSlotsQuery, replicationSlotsColumnsPG13)).WillReturnRows(
+		sqlmock.NewRows(replicationSlotsColumnNames).
+			AddRow("standby1", "physical", true, 5000.0, nil, 1000.0, nil, "reserved").
+			AddRow("standby2", "physical", true, 5000.0, nil, 1000.0, nil, "reserved").
+			AddRow("subscriber", "logical", true, 5000.0, 4200.0, 2000.0, nil, "reserved"),
+	)
+	mock.ExpectQuery(replicationSlotsMaxQuery).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(10))
+
+	values := make(map[string]float64)
+	for _, m := range collectMetrics(c, newReplicationSlotsCollector(), server) {
+		values[m.name] = m.value
+	}
+
+	c.Assert(values["pg_replication_slots_used"], Equals, 3.0)
+	c.Assert(values["pg_replication_slots_max"], Equals, 10.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	registerCollector("settings", defaultDisabled, masterOnly, newSettingsCollector)
+}
+
+var settingsInclude = kingpin.Flag("collector.settings.include", "Comma-separated list of the settings reported by the settings collector.").Default("max_connections,shared_buffers,effective_cache_size,work_mem,maintenance_work_mem,max_wal_size,checkpoint_timeout,statement_timeout,autovacuum").Envar("PG_EXPORTER_SETTINGS_INCLUDE").String()
+
+// Only the settings with a numeric or boolean vartype are selected, the
+// others have no value to report.
+const settingsQuery = `
+SELECT name, setting, COALESCE(unit, ''), short_desc, vartype
+FROM pg_settings
+WHERE vartype IN ('bool', 'integer', 'real')
+`
+
+// settingsCollector reports the included settings only, with the same metric
+// names as the settings metrics of the exporter. It requires
+// --disable-settings-metrics, see checkSettingsCollector.
+type settingsCollector struct {
+	include map[string]bool
+}
+
+// checkSettingsCollector rejects the settings collector without
+// --disable-settings-metrics, the metrics would be reported twice.
+func checkSettingsCollector(e *Exporter) error {
+	if enabled := collectorState["settings"]; enabled != nil && *enabled && !e.disableSettingsMetrics {
+		return errors.New("--collector.settings requires --disable-settings-metrics, both report the settings metrics")
+	}
+	return nil
+}
+
+func newSettingsCollector() Collector {
+	return &settingsCollector{include: newSettingsInclude(*settingsInclude)}
+}
+
+func newSettingsInclude(list string) map[string]bool {
+	include := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			include[name] = true
+		}
+	}
+	return include
+}
+
+// Update implements Collector.
+func (c *settingsCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, settingsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	for rows.Next() {
+		s := &pgSetting{}
+		if err := rows.Scan(&s.name, &s.setting, &s.unit, &s.shortDesc, &s.vartype); err != nil {
+			return err
+		}
+
+		if !c.include[s.name] {
+			continue
+		}
+		ch <- s.metric(server.labels)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,52 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type SettingsSuite struct{}
+
+var _ = Suite(&SettingsSuite{})
+
+func (s *SettingsSuite) TestInclude(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(settingsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"name", "setting", "unit", "short_desc", "vartype"}).
+			AddRow("shared_buffers", "16384", "8kB", "Sets the number of shared memory buffers used by the server.", "integer").
+			AddRow("statement_timeout", "30000", "ms", "Sets the maximum allowed duration of any statement.", "integer").
+			AddRow("autovacuum", "on", "", "Starts the autovacuum subprocess.", "bool").
+			AddRow("work_mem", "4096", "kB", "Sets the maximum memory to be used for query workspaces.", "integer"),
+	)
+
+	collector := &settingsCollector{include: newSettingsInclude(" shared_buffers, statement_timeout,autovacuum,")}
+	metrics := collectMetrics(c, collector, server)
+
+	c.Assert(metrics, HasLen, 3)
+	c.Assert(metrics[0].name, Equals, "pg_settings_shared_buffers_bytes")
+	c.Assert(metrics[0].value, Equals, 16384.0*8192)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{"server": "test:5432"})
+	c.Assert(metrics[1].name, Equals, "pg_settings_statement_timeout_seconds")
+	c.Assert(metrics[1].value, Equals, 30.0)
+	c.Assert(metrics[2].name, Equals, "pg_settings_autovacuum")
+	c.Assert(metrics[2].value, Equals, 1.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *SettingsSuite) TestCheckSettingsCollector(c *C) {
+	enabled := collectorState["settings"]
+	defer func(value bool) { *enabled = value }(*enabled)
+
+	*enabled = false
+	c.Check(checkSettingsCollector(NewExporter(nil)), IsNil)
+
+	// The settings metrics of the exporter would be reported twice.
+	*enabled = true
+	c.Check(checkSettingsCollector(NewExporter(nil)), ErrorMatches, "--collector.settings requires --disable-settings-metrics.*")
+	c.Check(checkSettingsCollector(NewExporter(nil, DisableSettingsMetrics(true))), IsNil)
+}
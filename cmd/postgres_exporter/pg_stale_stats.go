@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	registerCollector("stale_stats", defaultDisabled, everyDatabase, newStaleStatsCollector)
+}
+
+var (
+	staleStatsModFraction = kingpin.Flag("collector.stale_stats.mod-fraction", "Fraction of the estimated rows of a table which must have been modified since the last analyze for its statistics to be stale.").Default("0.1").Envar("PG_EXPORTER_STALE_STATS_MOD_FRACTION").Float64()
+	staleStatsMinAge      = kingpin.Flag("collector.stale_stats.min-age", "Time since the last analyze after which the statistics of a table may be stale.").Default("24h").Envar("PG_EXPORTER_STALE_STATS_MIN_AGE").Duration()
+)
+
+// last_analyze_age is NULL for tables which were never analyzed.
+const staleStatsQuery = `
+SELECT
+	current_database() AS datname,
+	s.schemaname,
+	s.relname,
+	s.n_mod_since_analyze,
+	c.reltuples,
+	EXTRACT(EPOCH FROM now() - GREATEST(s.last_analyze, s.last_autoanalyze))::float AS last_analyze_age
+FROM pg_stat_user_tables s
+	JOIN pg_class c ON c.oid = s.relid
+`
+
+type staleStatsCollector struct {
+	modFraction float64
+	minAge      time.Duration
+}
+
+func newStaleStatsCollector() Collector {
+	return &staleStatsCollector{
+		modFraction: *staleStatsModFraction,
+		minAge:      *staleStatsMinAge,
+	}
+}
+
+// Update implements Collector.
+func (c *staleStatsCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if server.lastMapVersion.LT(semver.MustParse("9.4.0")) {
+		log.Debugf("Skipping stale statistics on %q: PostgreSQL 9.4 or newer is required", server)
+		return nil
+	}
+
+	rows, err := server.db.QueryContext(ctx, staleStatsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "table", "stale_stats"),
+		"Table was modified a lot since it was last analyzed, a while ago, so the planner works with stale statistics",
+		[]string{"datname", "schemaname", "relname"}, server.labels,
+	)
+
+	for rows.Next() {
+		var (
+			datname, schemaname, relname string
+			modSinceAnalyze              int64
+			reltuples                    float64
+			lastAnalyzeAge               sql.NullFloat64
+		)
+		if err := rows.Scan(&datname, &schemaname, &relname, &modSinceAnalyze, &reltuples, &lastAnalyzeAge); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		if c.isStale(modSinceAnalyze, reltuples, lastAnalyzeAge) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, datname, schemaname, relname)
+		}
+	}
+
+	return rows.Err()
+}
+
+// isStale reports whether more than modFraction of the estimated rows were
+// modified since a table was last analyzed, and that was at least minAge ago.
+func (c *staleStatsCollector) isStale(modSinceAnalyze int64, reltuples float64, lastAnalyzeAge sql.NullFloat64) bool {
+	if float64(modSinceAnalyze) <= c.modFraction*reltuples {
+		return false
+	}
+	return !lastAnalyzeAge.Valid || lastAnalyzeAge.Float64 >= c.minAge.Seconds()
+}
@@ -0,0 +1,51 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StaleStatsSuite struct{}
+
+var _ = Suite(&StaleStatsSuite{})
+
+func (s *StaleStatsSuite) TestStaleStats(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+	server.collectorConfig = newCollectorConfig([]string{"scratch"})
+
+	day := (24 * time.Hour).Seconds()
+	mock.ExpectQuery(staleStatsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "schemaname", "relname", "n_mod_since_analyze", "reltuples", "last_analyze_age"}).
+			// Stale: many modifications, analyzed two days ago.
+			AddRow("postgres", "public", "orders", 5000, 10000, 2*day).
+			// Many modifications, but analyzed recently.
+			AddRow("postgres", "public", "events", 5000, 10000, 60).
+			// Analyzed long ago, but barely modified.
+			AddRow("postgres", "public", "countries", 1, 200, 30*day).
+			// Stale: never analyzed.
+			AddRow("postgres", "audit", "log", 100, 0, nil).
+			// Stale, but in an excluded database.
+			AddRow("scratch", "public", "orders", 5000, 10000, 2*day),
+	)
+
+	collector := &staleStatsCollector{modFraction: 0.1, minAge: 24 * time.Hour}
+	metrics := collectMetrics(c, collector, server)
+
+	c.Assert(metrics, HasLen, 2)
+	c.Assert(metrics[0].name, Equals, "pg_table_stale_stats")
+	c.Assert(metrics[0].value, Equals, 1.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":     "test:5432",
+		"datname":    "postgres",
+		"schemaname": "public",
+		"relname":    "orders",
+	})
+	c.Assert(metrics[1].labels["relname"], Equals, "log")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	registerDefaultMetricsCollector("stat_activity", defaultEnabled, masterOnly, newStatActivityCollector)
+}
+
+var statActivityIncludeUsename = kingpin.Flag("collector.stat_activity.include-usename", "Add the usename label to pg_stat_activity metrics, instead of an empty one.").Default("false").Envar("PG_EXPORTER_STAT_ACTIVITY_INCLUDE_USENAME").Bool()
+
+const statActivitySubsystem = "stat_activity"
+
+var statActivityLabels = []string{"datname", "usename", "state"}
+
+// Background processes which aren't connected to a database are skipped, as
+// with the former pg_stat_activity column mapping. The user names collapse to
+// an empty one unless $1 is true, as there can be many of them. Every state of
+// every database without connections is reported with a zero count, so that
+// the series don't disappear while there are no connections.
+const statActivityQuery = `
+WITH activity AS (
+	SELECT
+		datname,
+		CASE WHEN $1 THEN COALESCE(usename, '') ELSE '' END AS usename,
+		COALESCE(state, '') AS state,
+		count(*) AS count,
+		COALESCE(max(EXTRACT(EPOCH FROM now() - xact_start)), 0)::float AS max_tx_duration,
+		COALESCE(max(EXTRACT(EPOCH FROM now() - backend_start)), 0)::float AS max_backend_age
+	FROM pg_stat_activity
+	WHERE datname IS NOT NULL
+	GROUP BY 1, 2, 3
+)
+SELECT datname, usename, state, count, max_tx_duration, max_backend_age
+FROM activity
+UNION ALL
+SELECT pg_database.datname, '', states.state, 0, 0, 0
+FROM
+	(
+	  VALUES ('active'),
+	         ('idle'),
+	         ('idle in transaction'),
+	         ('idle in transaction (aborted)'),
+	         ('fastpath function call'),
+	         ('disabled')
+	) AS states(state) CROSS JOIN pg_database
+WHERE NOT EXISTS (
+	SELECT 1 FROM activity
+	WHERE activity.datname = pg_database.datname AND activity.state = states.state
+)
+`
+
+// The state column was added in PostgreSQL 9.2, the connections are reported
+// with the unknown state before, like the former column mapping did.
+const statActivityQueryPrePG92 = `
+SELECT
+	datname,
+	CASE WHEN $1 THEN COALESCE(usename, '') ELSE '' END AS usename,
+	'unknown' AS state,
+	count(*) AS count,
+	COALESCE(max(EXTRACT(EPOCH FROM now() - xact_start)), 0)::float AS max_tx_duration,
+	COALESCE(max(EXTRACT(EPOCH FROM now() - backend_start)), 0)::float AS max_backend_age
+FROM pg_stat_activity
+WHERE datname IS NOT NULL
+GROUP BY 1, 2
+`
+
+// wait_event_type and wait_event were added in PostgreSQL 9.6.
+const statActivityWaitEventsQuery = `
+SELECT
+	wait_event_type,
+	wait_event,
+	count(*) AS count
+FROM pg_stat_activity
+WHERE wait_event IS NOT NULL
+GROUP BY 1, 2
+`
+
+type statActivityCollector struct {
+	includeUsename bool
+}
+
+func newStatActivityCollector() Collector {
+	return &statActivityCollector{
+		includeUsename: *statActivityIncludeUsename,
+	}
+}
+
+// Update implements Collector.
+func (c *statActivityCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if err := c.updateActivity(ctx, server, ch); err != nil {
+		return err
+	}
+
+	if server.lastMapVersion.LT(semver.MustParse("9.6.0")) {
+		log.Debugf("Skipping pg_stat_activity wait events on %q: PostgreSQL 9.6 or newer is required", server)
+		return nil
+	}
+	return c.updateWaitEvents(ctx, server, ch)
+}
+
+func (c *statActivityCollector) updateActivity(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	query := statActivityQuery
+	if server.lastMapVersion.LT(semver.MustParse("9.2.0")) {
+		query = statActivityQueryPrePG92
+	}
+
+	rows, err := server.db.QueryContext(ctx, query, c.includeUsename)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	countDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, statActivitySubsystem, "count"),
+		"Number of connections in this state", statActivityLabels, server.labels,
+	)
+	maxTxDurationDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, statActivitySubsystem, "max_tx_duration_seconds"),
+		"Duration of the longest running transaction of the connections in this state, in seconds", statActivityLabels, server.labels,
+	)
+	// The name of the former pg_stat_activity column mapping, kept for the
+	// existing dashboards and alerts.
+	legacyMaxTxDurationDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, statActivitySubsystem, "max_tx_duration"),
+		"Duration of the longest running transaction of the connections in this state, in seconds (deprecated, use pg_stat_activity_max_tx_duration_seconds)", statActivityLabels, server.labels,
+	)
+
+	var oldestBackend float64
+	for rows.Next() {
+		var (
+			datname, usename, state      string
+			count, maxTxDuration, maxAge float64
+		)
+		if err := rows.Scan(&datname, &usename, &state, &count, &maxTxDuration, &maxAge); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(countDesc, prometheus.GaugeValue, count, datname, usename, state)
+		ch <- prometheus.MustNewConstMetric(maxTxDurationDesc, prometheus.GaugeValue, maxTxDuration, datname, usename, state)
+		ch <- prometheus.MustNewConstMetric(legacyMaxTxDurationDesc, prometheus.GaugeValue, maxTxDuration, datname, usename, state)
+		if maxAge > oldestBackend {
+			oldestBackend = maxAge
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		newDesc(statActivitySubsystem, "oldest_backend_seconds", "Time since the oldest connection was started, in seconds", server.labels),
+		prometheus.GaugeValue, oldestBackend,
+	)
+	return nil
+}
+
+func (c *statActivityCollector) updateWaitEvents(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, statActivityWaitEventsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, statActivitySubsystem, "wait_events"),
+		"Number of processes waiting for this event", []string{"wait_event_type", "wait_event"}, server.labels,
+	)
+
+	for rows.Next() {
+		var (
+			waitEventType, waitEvent string
+			count                    float64
+		)
+		if err := rows.Scan(&waitEventType, &waitEvent, &count); err != nil {
+			return err
+		}
+
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, count, waitEventType, waitEvent)
+	}
+	return rows.Err()
+}
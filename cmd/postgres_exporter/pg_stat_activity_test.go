@@ -0,0 +1,99 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatActivitySuite struct{}
+
+var _ = Suite(&StatActivitySuite{})
+
+var statActivityColumns = []string{"datname", "usename", "state", "count", "max_tx_duration", "max_backend_age"}
+
+func (s *StatActivitySuite) TestStatActivity(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statActivityQuery).WithArgs(false).WillReturnRows(
+		sqlmock.NewRows(statActivityColumns).
+			AddRow("postgres", "", "active", 3, 12.5, 300).
+			AddRow("app", "", "idle in transaction", 1, 60, 900).
+			AddRow("app", "", "idle", 0, 0, 0),
+	)
+	mock.ExpectQuery(statActivityWaitEventsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"wait_event_type", "wait_event", "count"}).
+			AddRow("Lock", "relation", 2),
+	)
+
+	metrics := collectMetrics(c, newStatActivityCollector(), server)
+
+	c.Assert(metrics, HasLen, 11)
+	c.Assert(metrics[0].name, Equals, "pg_stat_activity_count")
+	c.Assert(metrics[0].value, Equals, 3.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":  "test:5432",
+		"datname": "postgres",
+		"usename": "",
+		"state":   "active",
+	})
+	c.Assert(metrics[1].name, Equals, "pg_stat_activity_max_tx_duration_seconds")
+	c.Assert(metrics[1].value, Equals, 12.5)
+	c.Assert(metrics[2].name, Equals, "pg_stat_activity_max_tx_duration")
+	c.Assert(metrics[2].value, Equals, 12.5)
+	c.Assert(metrics[4].value, Equals, 60.0)
+
+	// The states without connections are reported with a zero count.
+	c.Assert(metrics[6].name, Equals, "pg_stat_activity_count")
+	c.Assert(metrics[6].value, Equals, 0.0)
+	c.Assert(metrics[6].labels["state"], Equals, "idle")
+
+	c.Assert(metrics[9].name, Equals, "pg_stat_activity_oldest_backend_seconds")
+	c.Assert(metrics[9].value, Equals, 900.0)
+	c.Assert(metrics[10].name, Equals, "pg_stat_activity_wait_events")
+	c.Assert(metrics[10].value, Equals, 2.0)
+	c.Assert(metrics[10].labels, DeepEquals, map[string]string{
+		"server":          "test:5432",
+		"wait_event_type": "Lock",
+		"wait_event":      "relation",
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatActivitySuite) TestStatActivityUsenameBeforeWaitEvents(c *C) {
+	server, mock := newMockServer(c, "9.5.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statActivityQuery).WithArgs(true).WillReturnRows(
+		sqlmock.NewRows(statActivityColumns).
+			AddRow("postgres", "alice", "active", 1, 0, 10),
+	)
+
+	metrics := collectMetrics(c, &statActivityCollector{includeUsename: true}, server)
+
+	c.Assert(metrics, HasLen, 4)
+	c.Assert(metrics[0].labels["usename"], Equals, "alice")
+	c.Assert(metrics[3].name, Equals, "pg_stat_activity_oldest_backend_seconds")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatActivitySuite) TestStatActivityPrePG92(c *C) {
+	server, mock := newMockServer(c, "9.1.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statActivityQueryPrePG92).WithArgs(false).WillReturnRows(
+		sqlmock.NewRows(statActivityColumns).
+			AddRow("postgres", "", "unknown", 2, 5, 10),
+	)
+
+	metrics := collectMetrics(c, newStatActivityCollector(), server)
+
+	c.Assert(metrics, HasLen, 4)
+	c.Assert(metrics[0].name, Equals, "pg_stat_activity_count")
+	c.Assert(metrics[0].value, Equals, 2.0)
+	c.Assert(metrics[0].labels["state"], Equals, "unknown")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
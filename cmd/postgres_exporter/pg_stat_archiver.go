@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+func init() {
+	registerDefaultMetricsCollector("stat_archiver", defaultEnabled, masterOnly, newStatArchiverCollector)
+}
+
+const statArchiverSubsystem = "stat_archiver"
+
+// The times are NULL until a WAL file was archived, or failed to be.
+const statArchiverQuery = `
+SELECT
+	archived_count::float,
+	failed_count::float,
+	EXTRACT(EPOCH FROM now() - last_archived_time)::float AS last_archive_age,
+	EXTRACT(EPOCH FROM now() - last_failed_time)::float AS last_failed_age
+FROM pg_stat_archiver
+`
+
+type statArchiverCollector struct{}
+
+func newStatArchiverCollector() Collector {
+	return &statArchiverCollector{}
+}
+
+// Update implements Collector.
+func (c *statArchiverCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if server.lastMapVersion.LT(semver.MustParse("9.4.0")) {
+		log.Debugf("Skipping pg_stat_archiver metrics on %q: PostgreSQL 9.4 or newer is required", server)
+		return nil
+	}
+
+	var (
+		archivedCount, failedCount    float64
+		lastArchiveAge, lastFailedAge sql.NullFloat64
+	)
+	if err := server.db.QueryRowContext(ctx, statArchiverQuery).Scan(&archivedCount, &failedCount, &lastArchiveAge, &lastFailedAge); err != nil {
+		return err
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		newDesc(statArchiverSubsystem, "archived_count", "Number of WAL files that have been successfully archived", server.labels),
+		prometheus.CounterValue, archivedCount,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		newDesc(statArchiverSubsystem, "failed_count", "Number of failed attempts for archiving WAL files", server.labels),
+		prometheus.CounterValue, failedCount,
+	)
+	if lastArchiveAge.Valid {
+		ch <- prometheus.MustNewConstMetric(
+			newDesc(statArchiverSubsystem, "last_archive_age_seconds", "Time since the last WAL file was successfully archived, in seconds", server.labels),
+			prometheus.GaugeValue, lastArchiveAge.Float64,
+		)
+	}
+	if lastFailedAge.Valid {
+		ch <- prometheus.MustNewConstMetric(
+			newDesc(statArchiverSubsystem, "last_failed_age_seconds", "Time since the last failed attempt to archive a WAL file, in seconds", server.labels),
+			prometheus.GaugeValue, lastFailedAge.Float64,
+		)
+	}
+	return nil
+}
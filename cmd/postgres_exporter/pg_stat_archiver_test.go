@@ -0,0 +1,53 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatArchiverSuite struct{}
+
+var _ = Suite(&StatArchiverSuite{})
+
+var statArchiverColumns = []string{"archived_count", "failed_count", "last_archive_age", "last_failed_age"}
+
+func (s *StatArchiverSuite) TestStatArchiver(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statArchiverQuery).WillReturnRows(
+		sqlmock.NewRows(statArchiverColumns).AddRow(1200, 3, 42.5, 3600),
+	)
+
+	metrics := collectMetrics(c, newStatArchiverCollector(), server)
+
+	c.Assert(metrics, HasLen, 4)
+	c.Assert(metrics[0].name, Equals, "pg_stat_archiver_archived_count")
+	c.Assert(metrics[0].value, Equals, 1200.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{"server": "test:5432"})
+	c.Assert(metrics[1].name, Equals, "pg_stat_archiver_failed_count")
+	c.Assert(metrics[1].value, Equals, 3.0)
+	c.Assert(metrics[2].name, Equals, "pg_stat_archiver_last_archive_age_seconds")
+	c.Assert(metrics[2].value, Equals, 42.5)
+	c.Assert(metrics[3].name, Equals, "pg_stat_archiver_last_failed_age_seconds")
+	c.Assert(metrics[3].value, Equals, 3600.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatArchiverSuite) TestStatArchiverNeverArchived(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statArchiverQuery).WillReturnRows(
+		sqlmock.NewRows(statArchiverColumns).AddRow(0, 0, nil, nil),
+	)
+
+	metrics := collectMetrics(c, newStatArchiverCollector(), server)
+
+	c.Assert(metrics, HasLen, 2)
+	c.Assert(metrics[1].name, Equals, "pg_stat_archiver_failed_count")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("stat_bgwriter", defaultEnabled, masterOnly, newStatBgwriterCollector)
+}
+
+// The checkpoints counters are exported by the pg_stat_bgwriter builtin
+// metrics, this only derives their ratio. They were moved to
+// pg_stat_checkpointer in PostgreSQL 17.
+const (
+	statBgwriterCheckpointsQuery = `
+SELECT checkpoints_req::float, checkpoints_timed::float
+FROM pg_stat_bgwriter
+`
+	statCheckpointerCheckpointsQuery = `
+SELECT num_requested::float, num_timed::float
+FROM pg_stat_checkpointer
+`
+)
+
+type statBgwriterCollector struct{}
+
+func newStatBgwriterCollector() Collector {
+	return &statBgwriterCollector{}
+}
+
+// Update implements Collector.
+func (c *statBgwriterCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	query := statBgwriterCheckpointsQuery
+	if server.lastMapVersion.GE(semver.MustParse("17.0.0")) {
+		query = statCheckpointerCheckpointsQuery
+	}
+
+	var requested, timed float64
+	if err := server.db.QueryRowContext(ctx, query).Scan(&requested, &timed); err != nil {
+		return err
+	}
+
+	// There is no ratio until the first checkpoint.
+	if requested+timed == 0 {
+		return nil
+	}
+	ch <- prometheus.MustNewConstMetric(
+		newDesc("stat_bgwriter", "checkpoint_requested_ratio", "Ratio of the requested checkpoints to all the checkpoints performed, a high ratio means max_wal_size is too small", server.labels),
+		prometheus.GaugeValue, requested/(requested+timed),
+	)
+	return nil
+}
@@ -0,0 +1,47 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatBgwriterSuite struct{}
+
+var _ = Suite(&StatBgwriterSuite{})
+
+func (s *StatBgwriterSuite) TestCheckpointRequestedRatio(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statBgwriterCheckpointsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"checkpoints_req", "checkpoints_timed"}).AddRow(30, 90),
+	)
+	metrics := collectMetrics(c, newStatBgwriterCollector(), server)
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].name, Equals, "pg_stat_bgwriter_checkpoint_requested_ratio")
+	c.Assert(metrics[0].value, Equals, 0.25)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{"server": "test:5432"})
+
+	// No checkpoint yet.
+	mock.ExpectQuery(statBgwriterCheckpointsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"checkpoints_req", "checkpoints_timed"}).AddRow(0, 0),
+	)
+	c.Assert(collectMetrics(c, newStatBgwriterCollector(), server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatBgwriterSuite) TestCheckpointer(c *C) {
+	server, mock := newMockServer(c, "17.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statCheckpointerCheckpointsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"num_requested", "num_timed"}).AddRow(1, 3),
+	)
+	metrics := collectMetrics(c, newStatBgwriterCollector(), server)
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].value, Equals, 0.25)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
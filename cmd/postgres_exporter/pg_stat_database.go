@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("stat_database", defaultEnabled, masterOnly, newStatDatabaseCollector)
+}
+
+const statDatabaseSubsystem = "stat_database"
+
+// The shared objects have a row with a NULL datname since PostgreSQL 12, it
+// is skipped. The counters themselves are reported by the pg_stat_database
+// column mapping.
+const statDatabaseQuery = `
+SELECT
+	datname,
+	blks_read::float AS blks_read,
+	blks_hit::float AS blks_hit
+FROM pg_stat_database
+WHERE datname IS NOT NULL
+`
+
+// statDatabaseBlocks are the block counters of a database.
+type statDatabaseBlocks struct {
+	read, hit float64
+}
+
+type statDatabaseCollector struct {
+	mtx sync.Mutex
+	// Counters seen by the previous scrape, by database.
+	last map[string]statDatabaseBlocks
+}
+
+func newStatDatabaseCollector() Collector {
+	return &statDatabaseCollector{last: make(map[string]statDatabaseBlocks)}
+}
+
+// Update implements Collector.
+func (c *statDatabaseCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, statDatabaseQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	blksHitRatioDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, statDatabaseSubsystem, "blks_hit_ratio"),
+		"Fraction of the blocks found in the buffer cache out of all blocks accessed in this database since the previous scrape",
+		[]string{"datname"}, server.labels,
+	)
+
+	blocks := make(map[string]statDatabaseBlocks)
+	for rows.Next() {
+		var (
+			datname string
+			b       statDatabaseBlocks
+		)
+		if err := rows.Scan(&datname, &b.read, &b.hit); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+		blocks[datname] = b
+
+		if ratio, ok := c.observe(datname, b); ok {
+			ch <- prometheus.MustNewConstMetric(blksHitRatioDesc, prometheus.GaugeValue, ratio, datname)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Forget the dropped databases.
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for datname := range c.last {
+		if _, ok := blocks[datname]; !ok {
+			delete(c.last, datname)
+		}
+	}
+	return nil
+}
+
+// observe records the counters of a database and returns its hit ratio since
+// the previous scrape. There is no ratio on the first scrape, when no block
+// was accessed meanwhile, or when the counters went backwards because the
+// statistics were reset.
+func (c *statDatabaseCollector) observe(datname string, b statDatabaseBlocks) (float64, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	last, ok := c.last[datname]
+	c.last[datname] = b
+
+	read, hit := b.read-last.read, b.hit-last.hit
+	if !ok || read < 0 || hit < 0 || read+hit == 0 {
+		return 0, false
+	}
+	return hit / (read + hit), true
+}
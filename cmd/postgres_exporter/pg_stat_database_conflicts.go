@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerDefaultMetricsCollector("stat_database_conflicts", defaultEnabled, masterOnly, newStatDatabaseConflictsCollector)
+}
+
+const statDatabaseConflictsSubsystem = "stat_database_conflicts"
+
+// statDatabaseConflictsCounters are the counters of queries canceled by a
+// recovery conflict, in the order of the columns of statDatabaseConflictsQuery.
+// They are only incremented on a standby.
+var statDatabaseConflictsCounters = []struct {
+	name, help string
+}{
+	{"confl_tablespace", "Number of queries in this database that have been canceled due to dropped tablespaces"},
+	{"confl_lock", "Number of queries in this database that have been canceled due to lock timeouts"},
+	{"confl_snapshot", "Number of queries in this database that have been canceled due to old snapshots"},
+	{"confl_bufferpin", "Number of queries in this database that have been canceled due to pinned buffers"},
+	{"confl_deadlock", "Number of queries in this database that have been canceled due to deadlocks"},
+}
+
+const statDatabaseConflictsQuery = `
+SELECT
+	datname,
+	confl_tablespace::float,
+	confl_lock::float,
+	confl_snapshot::float,
+	confl_bufferpin::float,
+	confl_deadlock::float
+FROM pg_stat_database_conflicts
+`
+
+type statDatabaseConflictsCollector struct {
+	mtx sync.Mutex
+	// Conflicts of all the databases seen by the previous scrape, and when.
+	lastConflicts float64
+	lastAt        time.Time
+
+	now func() time.Time
+}
+
+func newStatDatabaseConflictsCollector() Collector {
+	return &statDatabaseConflictsCollector{now: time.Now}
+}
+
+// Update implements Collector.
+func (c *statDatabaseConflictsCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, statDatabaseConflictsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	descs := make([]*prometheus.Desc, len(statDatabaseConflictsCounters))
+	for i, counter := range statDatabaseConflictsCounters {
+		descs[i] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, statDatabaseConflictsSubsystem, counter.name),
+			counter.help, []string{"datname"}, server.labels,
+		)
+	}
+
+	var conflicts float64
+	for rows.Next() {
+		var (
+			datname string
+			values  = make([]float64, len(statDatabaseConflictsCounters))
+		)
+		dest := []interface{}{&datname}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		for i, value := range values {
+			ch <- prometheus.MustNewConstMetric(descs[i], prometheus.CounterValue, value, datname)
+			conflicts += value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if rate, ok := c.observe(conflicts); ok {
+		ch <- prometheus.MustNewConstMetric(
+			newDesc("recovery_conflict", "rate", "Queries canceled by recovery conflicts per second in all databases since the previous scrape", server.labels),
+			prometheus.GaugeValue, rate,
+		)
+	}
+	return nil
+}
+
+// observe records the conflicts of a scrape and returns the rate at which
+// they grew since the previous scrape. There is no rate on the first scrape,
+// or when the counters went backwards because the statistics were reset.
+func (c *statDatabaseConflictsCollector) observe(conflicts float64) (float64, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.now()
+	lastConflicts, lastAt := c.lastConflicts, c.lastAt
+	c.lastConflicts, c.lastAt = conflicts, now
+
+	elapsed := now.Sub(lastAt).Seconds()
+	if lastAt.IsZero() || conflicts < lastConflicts || elapsed <= 0 {
+		return 0, false
+	}
+	return (conflicts - lastConflicts) / elapsed, true
+}
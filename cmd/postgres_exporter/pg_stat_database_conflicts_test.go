@@ -0,0 +1,72 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatDatabaseConflictsSuite struct{}
+
+var _ = Suite(&StatDatabaseConflictsSuite{})
+
+func (s *StatDatabaseConflictsSuite) TestConflicts(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+	server.collectorConfig = newCollectorConfig([]string{"scratch"})
+
+	mock.ExpectQuery(statDatabaseConflictsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "confl_tablespace", "confl_lock", "confl_snapshot", "confl_bufferpin", "confl_deadlock"}).
+			AddRow("app", 0, 2, 15, 1, 0).
+			AddRow("scratch", 0, 0, 3, 0, 0),
+	)
+
+	metrics := collectMetrics(c, newStatDatabaseConflictsCollector(), server)
+
+	// The excluded database is skipped.
+	c.Assert(metrics, HasLen, 5)
+	c.Assert(metrics[0].name, Equals, "pg_stat_database_conflicts_confl_tablespace")
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{"server": "test:5432", "datname": "app"})
+	c.Assert(metrics[1].name, Equals, "pg_stat_database_conflicts_confl_lock")
+	c.Assert(metrics[1].value, Equals, 2.0)
+	c.Assert(metrics[2].name, Equals, "pg_stat_database_conflicts_confl_snapshot")
+	c.Assert(metrics[2].value, Equals, 15.0)
+	c.Assert(metrics[4].name, Equals, "pg_stat_database_conflicts_confl_deadlock")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatDatabaseConflictsSuite) TestConflictRate(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	columns := []string{"datname", "confl_tablespace", "confl_lock", "confl_snapshot", "confl_bufferpin", "confl_deadlock"}
+	mock.ExpectQuery(statDatabaseConflictsQuery).WillReturnRows(
+		sqlmock.NewRows(columns).AddRow("app", 0, 2, 15, 1, 0).AddRow("reports", 0, 0, 3, 0, 0),
+	)
+	mock.ExpectQuery(statDatabaseConflictsQuery).WillReturnRows(
+		sqlmock.NewRows(columns).AddRow("app", 0, 4, 25, 1, 0).AddRow("reports", 0, 0, 9, 0, 0),
+	)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	collector := newStatDatabaseConflictsCollector().(*statDatabaseConflictsCollector)
+	collector.now = func() time.Time { return now }
+
+	// The first scrape only sets the baseline.
+	c.Assert(collectMetrics(c, collector, server), HasLen, 10)
+
+	now = now.Add(30 * time.Second)
+	metrics := collectMetrics(c, collector, server)
+	c.Assert(metrics, HasLen, 11)
+	c.Assert(metrics[10].name, Equals, "pg_recovery_conflict_rate")
+	c.Assert(metrics[10].value, Equals, 0.6)
+	c.Assert(metrics[10].labels, DeepEquals, map[string]string{"server": "test:5432"})
+
+	// The per-database counters are still exported.
+	c.Assert(metrics[2].name, Equals, "pg_stat_database_conflicts_confl_snapshot")
+	c.Assert(metrics[2].value, Equals, 25.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
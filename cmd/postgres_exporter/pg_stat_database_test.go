@@ -0,0 +1,42 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatDatabaseSuite struct{}
+
+var _ = Suite(&StatDatabaseSuite{})
+
+func (s *StatDatabaseSuite) TestBlksHitRatio(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	collector := newStatDatabaseCollector()
+	scrape := func(rows *sqlmock.Rows) []metricResult {
+		mock.ExpectQuery(statDatabaseQuery).WillReturnRows(rows)
+		return collectMetrics(c, collector, server)
+	}
+	columns := []string{"datname", "blks_read", "blks_hit"}
+
+	// There is no ratio before the second scrape.
+	c.Assert(scrape(sqlmock.NewRows(columns).AddRow("app", 100, 900).AddRow("idle", 0, 0)), HasLen, 0)
+
+	// No ratio is reported for a database where no block was accessed, or
+	// whose statistics were reset.
+	metrics := scrape(sqlmock.NewRows(columns).AddRow("app", 150, 1350).AddRow("idle", 0, 0).AddRow("new", 1, 1))
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].name, Equals, "pg_stat_database_blks_hit_ratio")
+	c.Assert(metrics[0].value, Equals, 0.9)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{"server": "test:5432", "datname": "app"})
+
+	metrics = scrape(sqlmock.NewRows(columns).AddRow("app", 10, 10).AddRow("new", 2, 4))
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].labels["datname"], Equals, "new")
+	c.Assert(metrics[0].value, Equals, 0.75)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	registerCollector("stat_io", defaultEnabled, masterOnly, newStatIOCollector)
+}
+
+var statIOBulkwrite = kingpin.Flag("collector.stat_io.bulkwrite", "Report the I/O of the bulkwrite context, e.g. of COPY and CREATE TABLE AS, summed per backend type.").Default("false").Envar("PG_EXPORTER_STAT_IO_BULKWRITE").Bool()
+
+var statIOSyncWriteRatio = kingpin.Flag("collector.stat_io.sync-write-ratio", "Report the writebacks and fsyncs per write of each backend type, to estimate their synchronous I/O overhead.").Default("false").Envar("PG_EXPORTER_STAT_IO_SYNC_WRITE_RATIO").Bool()
+
+var statIOAggregate = kingpin.Flag("collector.stat_io.aggregate", "Sum the per-row counters of pg_stat_io to reduce the number of series, one of: [none, backend_type, all].").Default(statIOAggregateNone).Envar("PG_EXPORTER_STAT_IO_AGGREGATE").Enum(statIOAggregateNone, statIOAggregateBackendType, statIOAggregateAll)
+
+var statIOBackendFsyncMismatch = kingpin.Flag("collector.stat_io.backend-fsync-mismatch", "Compare the backend fsyncs of pg_stat_io with pg_stat_bgwriter on PostgreSQL 16, with an extra query.").Default("false").Envar("PG_EXPORTER_STAT_IO_BACKEND_FSYNC_MISMATCH").Bool()
+
+var statIOGrandTotal = kingpin.Flag("collector.stat_io.grand-total", "Report the reads, writes and extends summed over all rows of pg_stat_io.").Default("false").Envar("PG_EXPORTER_STAT_IO_GRAND_TOTAL").Bool()
+
+const statIOSubsystem = "stat_io"
+
+// The aggregations of --collector.stat_io.aggregate: the per-row counters
+// keep all the labels, are summed over the I/O objects and contexts, or are
+// summed over all rows.
+const (
+	statIOAggregateNone        = "none"
+	statIOAggregateBackendType = "backend_type"
+	statIOAggregateAll         = "all"
+)
+
+var statIOLabels = []string{"backend_type", "io_object", "io_context"}
+
+// statIOCounters are the per-row counters of pg_stat_io, in the order of the
+// columns selected by the queries below. NULL values are not emitted, the
+// view reports NULL for operations which can't happen in a context.
+var statIOCounters = []struct {
+	name, help string
+}{
+	{"reads_total", "Number of read operations"},
+	{"writes_total", "Number of write operations"},
+	{"writebacks_total", "Number of units requested the kernel to write out to permanent storage"},
+	{"extends_total", "Number of relation extend operations"},
+	{"hits_total", "Number of times a desired block was found in a shared buffer"},
+	{"evictions_total", "Number of times a block has been written out from a shared or local buffer in order to make it available for another use"},
+	{"reuses_total", "Number of times an existing buffer in a size-limited ring buffer outside of shared buffers was reused"},
+	{"fsyncs_total", "Number of fsync calls"},
+	{"read_bytes_total", "Total size of read operations, in bytes"},
+	{"write_bytes_total", "Total size of write operations, in bytes"},
+	{"extend_bytes_total", "Total size of relation extend operations, in bytes"},
+	{"read_time_seconds_total", "Time spent in read operations, in seconds"},
+	{"write_time_seconds_total", "Time spent in write operations, in seconds"},
+	{"writeback_time_seconds_total", "Time spent in writeback operations, in seconds"},
+	{"extend_time_seconds_total", "Time spent in relation extend operations, in seconds"},
+	{"fsync_time_seconds_total", "Time spent in fsync operations, in seconds"},
+}
+
+// The times are in milliseconds, and always 0 with track_io_timing off. They
+// are NULL then, so that they aren't reported.
+const statIOTimeColumns = `	CASE WHEN current_setting('track_io_timing')::bool THEN read_time / 1000 END AS read_time,
+	CASE WHEN current_setting('track_io_timing')::bool THEN write_time / 1000 END AS write_time,
+	CASE WHEN current_setting('track_io_timing')::bool THEN writeback_time / 1000 END AS writeback_time,
+	CASE WHEN current_setting('track_io_timing')::bool THEN extend_time / 1000 END AS extend_time,
+	CASE WHEN current_setting('track_io_timing')::bool THEN fsync_time / 1000 END AS fsync_time,`
+
+// The byte columns were added in PostgreSQL 18. Before that every operation
+// has the size of op_bytes, so the same counters are derived from the
+// operation counts. op_bytes is NULL in some contexts, and so are the bytes.
+const (
+	statIOQuery = `
+SELECT
+	backend_type,
+	object,
+	context,
+	reads,
+	writes,
+	writebacks,
+	extends,
+	hits,
+	evictions,
+	reuses,
+	fsyncs,
+	read_bytes,
+	write_bytes,
+	extend_bytes,
+` + statIOTimeColumns + `
+	stats_reset
+FROM pg_stat_io
+`
+	statIOQueryPrePG18 = `
+SELECT
+	backend_type,
+	object,
+	context,
+	reads,
+	writes,
+	writebacks,
+	extends,
+	hits,
+	evictions,
+	reuses,
+	fsyncs,
+	reads::numeric * op_bytes AS read_bytes,
+	writes::numeric * op_bytes AS write_bytes,
+	extends::numeric * op_bytes AS extend_bytes,
+` + statIOTimeColumns + `
+	stats_reset
+FROM pg_stat_io
+`
+)
+
+// On PostgreSQL 16 the fsyncs of backends which couldn't forward them to the
+// checkpointer are counted both by pg_stat_bgwriter and by pg_stat_io. It was
+// removed from pg_stat_bgwriter in PostgreSQL 17.
+const statIOBackendFsyncQuery = `SELECT buffers_backend_fsync FROM pg_stat_bgwriter`
+
+// The positions of some counters in statIOCounters.
+const (
+	statIOReadsIndex      = 0
+	statIOWritesIndex     = 1
+	statIOWritebacksIndex = 2
+	statIOExtendsIndex    = 3
+	statIOFsyncsIndex     = 7
+)
+
+// statIOGrandTotals are the counters summed over all rows with
+// --collector.stat_io.grand-total.
+var statIOGrandTotals = []struct {
+	index      int
+	name, help string
+}{
+	{statIOReadsIndex, "total_reads", "Number of read operations of all backend types, objects and contexts"},
+	{statIOWritesIndex, "total_writes", "Number of write operations of all backend types, objects and contexts"},
+	{statIOExtendsIndex, "total_extends", "Number of relation extend operations of all backend types, objects and contexts"},
+}
+
+// statIOWALTotals are the counters of the WAL, summed over the backend types
+// and contexts. The WAL is in pg_stat_io since PostgreSQL 18.
+var statIOWALTotals = []struct {
+	index      int
+	name, help string
+}{
+	{statIOWritesIndex, "wal_writes_total", "Number of WAL write operations of all backend types and contexts"},
+	{statIOFsyncsIndex, "wal_fsyncs_total", "Number of WAL fsync calls of all backend types and contexts"},
+}
+
+// statIOObject identifies the extends counted for a backend type and object.
+type statIOObject struct {
+	backendType, object string
+}
+
+// statIOSyncWrites are the writes, and the writebacks and fsyncs, of a
+// backend type.
+type statIOSyncWrites struct {
+	writes, syncs float64
+}
+
+// statIOExtends is the number of extends seen by a scrape.
+type statIOExtends struct {
+	value float64
+	at    time.Time
+}
+
+type statIOCollector struct {
+	// How the per-row counters are summed, one of statIOAggregate*.
+	aggregate string
+	// Whether to report the I/O of the bulkwrite context.
+	bulkwrite bool
+	// Whether to report the counters summed over all rows.
+	grandTotal bool
+	// Whether to report the writebacks and fsyncs per write.
+	syncWriteRatio bool
+	// Whether to compare the backend fsyncs with pg_stat_bgwriter on PG16.
+	backendFsyncMismatch bool
+
+	mtx sync.Mutex
+	// Last seen stats_reset and the number of resets observed since start.
+	lastReset time.Time
+	resets    float64
+	// Extends seen by the previous scrape, to compute the extend rate.
+	lastExtends map[statIOObject]statIOExtends
+
+	now func() time.Time
+}
+
+func newStatIOCollector() Collector {
+	return &statIOCollector{
+		aggregate:            *statIOAggregate,
+		bulkwrite:            *statIOBulkwrite,
+		grandTotal:           *statIOGrandTotal,
+		syncWriteRatio:       *statIOSyncWriteRatio,
+		backendFsyncMismatch: *statIOBackendFsyncMismatch,
+		lastExtends:          make(map[statIOObject]statIOExtends),
+		now:                  time.Now,
+	}
+}
+
+// Update implements Collector.
+func (c *statIOCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if server.lastMapVersion.LT(semver.MustParse("16.0.0")) {
+		log.Debugf("Skipping pg_stat_io on %q: PostgreSQL 16 or newer is required", server)
+		return nil
+	}
+
+	query := statIOQueryPrePG18
+	if server.lastMapVersion.GE(semver.MustParse("18.0.0")) {
+		query = statIOQuery
+	}
+
+	rows, err := server.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	labels := statIOLabels
+	switch c.aggregate {
+	case statIOAggregateBackendType:
+		labels = []string{"backend_type"}
+	case statIOAggregateAll:
+		labels = nil
+	}
+	descs := make([]*prometheus.Desc, len(statIOCounters))
+	for i, counter := range statIOCounters {
+		descs[i] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, statIOSubsystem, counter.name),
+			counter.help, labels, server.labels,
+		)
+	}
+
+	var (
+		statsReset    sql.NullTime
+		backendFsyncs float64
+		extends       = make(map[statIOObject]float64)
+		bulkwrite     = make(map[string][]sql.NullFloat64)
+		aggregated    = make(map[string][]sql.NullFloat64)
+		totals        = make([]float64, len(statIOCounters))
+		walTotals     = make([]float64, len(statIOCounters))
+		walRows       bool
+		syncWrites    = make(map[string]*statIOSyncWrites)
+	)
+	for rows.Next() {
+		var (
+			backendType, object, ioContext string
+			values                         = make([]sql.NullFloat64, len(statIOCounters))
+			rowReset                       sql.NullTime
+		)
+
+		dest := []interface{}{&backendType, &object, &ioContext}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		dest = append(dest, &rowReset)
+
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		switch c.aggregate {
+		case statIOAggregateBackendType:
+			addStatIOValues(aggregated, backendType, values)
+		case statIOAggregateAll:
+			addStatIOValues(aggregated, "", values)
+		default:
+			for i, value := range values {
+				if value.Valid {
+					ch <- prometheus.MustNewConstMetric(descs[i], prometheus.CounterValue, value.Float64, backendType, object, ioContext)
+				}
+			}
+		}
+
+		for i, value := range values {
+			if value.Valid {
+				totals[i] += value.Float64
+				if object == "wal" {
+					walTotals[i] += value.Float64
+				}
+			}
+		}
+		if object == "wal" {
+			walRows = true
+		}
+
+		key := c.aggregateKey(backendType, object)
+		if v := values[statIOExtendsIndex]; v.Valid {
+			extends[key] += v.Float64
+		}
+
+		if c.syncWriteRatio && object != "wal" {
+			sums, ok := syncWrites[key.backendType]
+			if !ok {
+				sums = &statIOSyncWrites{}
+				syncWrites[key.backendType] = sums
+			}
+			sums.writes += values[statIOWritesIndex].Float64
+			sums.syncs += values[statIOWritebacksIndex].Float64 + values[statIOFsyncsIndex].Float64
+		}
+
+		if c.bulkwrite && ioContext == "bulkwrite" {
+			addStatIOValues(bulkwrite, key.backendType, values)
+		}
+
+		if v := values[statIOFsyncsIndex]; v.Valid && backendType != "checkpointer" {
+			backendFsyncs += v.Float64
+		}
+
+		if rowReset.Valid && (!statsReset.Valid || rowReset.Time.After(statsReset.Time)) {
+			statsReset = rowReset
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for backendType, sums := range aggregated {
+		for i, sum := range sums {
+			if sum.Valid {
+				ch <- prometheus.MustNewConstMetric(descs[i], prometheus.CounterValue, sum.Float64, c.backendTypeLabelValues(backendType)...)
+			}
+		}
+	}
+
+	// The series summed per backend type are summed over all of them too
+	// with --collector.stat_io.aggregate=all.
+	backendTypeLabels := []string{"backend_type"}
+	if c.aggregate == statIOAggregateAll {
+		backendTypeLabels = nil
+	}
+
+	// The bulkwrite context is used by COPY and CREATE TABLE AS, summed over
+	// the I/O objects to show these workloads separately.
+	for backendType, sums := range bulkwrite {
+		for i, sum := range sums {
+			if !sum.Valid {
+				continue
+			}
+			desc := prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, statIOSubsystem, "bulkwrite_"+statIOCounters[i].name),
+				statIOCounters[i].help+" in the bulkwrite context",
+				backendTypeLabels, server.labels,
+			)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, sum.Float64, c.backendTypeLabelValues(backendType)...)
+		}
+	}
+
+	if c.grandTotal {
+		for _, total := range statIOGrandTotals {
+			ch <- prometheus.MustNewConstMetric(
+				newDesc(statIOSubsystem, total.name, total.help, server.labels),
+				prometheus.CounterValue, totals[total.index],
+			)
+		}
+	}
+
+	// The writebacks and fsyncs of the relations per write, e.g. to tune
+	// backend_flush_after. Backend types which didn't write are skipped.
+	syncWriteRatioDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, statIOSubsystem, "sync_write_ratio"),
+		"Writebacks and fsyncs per write operation of the backend type, summed over the relation objects and contexts",
+		backendTypeLabels, server.labels,
+	)
+	for backendType, sums := range syncWrites {
+		if sums.writes > 0 {
+			ch <- prometheus.MustNewConstMetric(syncWriteRatioDesc, prometheus.GaugeValue, sums.syncs/sums.writes, c.backendTypeLabelValues(backendType)...)
+		}
+	}
+
+	if walRows && server.lastMapVersion.GE(semver.MustParse("18.0.0")) {
+		for _, total := range statIOWALTotals {
+			ch <- prometheus.MustNewConstMetric(
+				newDesc(statIOSubsystem, total.name, total.help, server.labels),
+				prometheus.CounterValue, walTotals[total.index],
+			)
+		}
+	}
+
+	extendRateLabels := []string{"backend_type", "io_object"}
+	if c.aggregate != statIOAggregateNone && c.aggregate != "" {
+		extendRateLabels = backendTypeLabels
+	}
+	extendRateDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, statIOSubsystem, "extend_rate"),
+		"Relation extend operations per second since the previous scrape",
+		extendRateLabels, server.labels,
+	)
+	for object, rate := range c.observeExtends(extends) {
+		labelValues := c.backendTypeLabelValues(object.backendType)
+		if len(extendRateLabels) == 2 {
+			labelValues = append(labelValues, object.object)
+		}
+		ch <- prometheus.MustNewConstMetric(extendRateDesc, prometheus.GaugeValue, rate, labelValues...)
+	}
+
+	if c.backendFsyncMismatch && server.lastMapVersion.LT(semver.MustParse("17.0.0")) {
+		var bgwriterFsyncs float64
+		if err := server.db.QueryRowContext(ctx, statIOBackendFsyncQuery).Scan(&bgwriterFsyncs); err != nil {
+			return err
+		}
+		ch <- prometheus.MustNewConstMetric(
+			newDesc(statIOSubsystem, "backend_fsync_mismatch", "Fsyncs of backends other than the checkpointer in pg_stat_io minus buffers_backend_fsync in pg_stat_bgwriter, which should be close to 0 (PostgreSQL 16)", server.labels),
+			prometheus.GaugeValue, backendFsyncs-bgwriterFsyncs,
+		)
+	}
+
+	if server.lastMapVersion.GE(semver.MustParse("17.0.0")) && statsReset.Valid {
+		ch <- prometheus.MustNewConstMetric(
+			newDesc(statIOSubsystem, "resets_total", "Number of times pg_stat_io was observed to be reset, based on stats_reset", server.labels),
+			prometheus.CounterValue, c.observeReset(statsReset.Time),
+		)
+	}
+
+	return nil
+}
+
+// aggregateKey returns the backend type and object of a row kept by
+// --collector.stat_io.aggregate, the others are summed over.
+func (c *statIOCollector) aggregateKey(backendType, object string) statIOObject {
+	switch c.aggregate {
+	case statIOAggregateBackendType:
+		return statIOObject{backendType: backendType}
+	case statIOAggregateAll:
+		return statIOObject{}
+	}
+	return statIOObject{backendType, object}
+}
+
+// backendTypeLabelValues returns the value of the backend_type label, which
+// is left out when all rows are summed.
+func (c *statIOCollector) backendTypeLabelValues(backendType string) []string {
+	if c.aggregate == statIOAggregateAll {
+		return nil
+	}
+	return []string{backendType}
+}
+
+// addStatIOValues adds the counters of a row to the sums of the key. A sum
+// stays NULL until a row has a value for it.
+func addStatIOValues(sums map[string][]sql.NullFloat64, key string, values []sql.NullFloat64) {
+	keySums, ok := sums[key]
+	if !ok {
+		keySums = make([]sql.NullFloat64, len(statIOCounters))
+		sums[key] = keySums
+	}
+	for i, value := range values {
+		if value.Valid {
+			keySums[i].Float64 += value.Float64
+			keySums[i].Valid = true
+		}
+	}
+}
+
+// observeReset records the stats_reset timestamp of a scrape and returns the
+// number of resets seen so far. The first scrape only sets the baseline.
+func (c *statIOCollector) observeReset(statsReset time.Time) float64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.lastReset.IsZero() && !statsReset.Equal(c.lastReset) {
+		c.resets++
+	}
+	c.lastReset = statsReset
+	return c.resets
+}
+
+// observeExtends records the extends of a scrape, summed over the I/O
+// contexts, and returns the rate at which they grew since the previous scrape.
+// Nothing is returned for objects seen for the first time, or whose counter
+// went backwards because the statistics were reset.
+func (c *statIOCollector) observeExtends(extends map[statIOObject]float64) map[statIOObject]float64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.now()
+	rates := make(map[statIOObject]float64)
+	for object, value := range extends {
+		last, ok := c.lastExtends[object]
+		if elapsed := now.Sub(last.at).Seconds(); ok && value >= last.value && elapsed > 0 {
+			rates[object] = (value - last.value) / elapsed
+		}
+	}
+
+	c.lastExtends = make(map[statIOObject]statIOExtends, len(extends))
+	for object, value := range extends {
+		c.lastExtends[object] = statIOExtends{value: value, at: now}
+	}
+	return rates
+}
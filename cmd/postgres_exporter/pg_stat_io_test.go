@@ -0,0 +1,457 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatIOSuite struct{}
+
+var _ = Suite(&StatIOSuite{})
+
+var statIOColumns = []string{
+	"backend_type", "object", "context",
+	"reads", "writes", "writebacks", "extends", "hits", "evictions", "reuses", "fsyncs",
+	"read_bytes", "write_bytes", "extend_bytes",
+	"read_time", "write_time", "writeback_time", "extend_time", "fsync_time",
+	"stats_reset",
+}
+
+func (s *StatIOSuite) TestStatIO(c *C) {
+	server, mock := newMockServer(c, "16.2.0")
+	defer server.db.Close()
+
+	reset := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(statIOQueryPrePG18).WillReturnRows(
+		sqlmock.NewRows(statIOColumns).
+			AddRow("client backend", "relation", "normal", 10, 5, 0, 2, 100, 1, nil, 3, 81920, 40960, 16384, nil, nil, nil, nil, nil, reset).
+			AddRow("client backend", "temp relation", "normal", 1, 1, nil, 1, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, reset),
+	)
+
+	metrics := collectMetrics(c, newStatIOCollector(), server)
+
+	// NULL columns are skipped, resets are only reported on PG17+, and
+	// pg_stat_bgwriter is not queried by default.
+	c.Assert(metrics, HasLen, 13)
+	c.Assert(metrics[0].name, Equals, "pg_stat_io_reads_total")
+	c.Assert(metrics[0].value, Equals, 10.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":       "test:5432",
+		"backend_type": "client backend",
+		"io_object":    "relation",
+		"io_context":   "normal",
+	})
+	c.Assert(metrics[7].name, Equals, "pg_stat_io_read_bytes_total")
+	c.Assert(metrics[7].value, Equals, 81920.0)
+	c.Assert(metrics[9].name, Equals, "pg_stat_io_extend_bytes_total")
+	c.Assert(metrics[9].value, Equals, 16384.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatIOSuite) TestStatIOResets(c *C) {
+	server, mock := newMockServer(c, "17.0.0")
+	defer server.db.Close()
+
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	for _, reset := range []time.Time{first, first, second} {
+		mock.ExpectQuery(statIOQueryPrePG18).WillReturnRows(
+			sqlmock.NewRows(statIOColumns).
+				AddRow("checkpointer", "relation", "normal", nil, 5, 5, nil, nil, nil, nil, 1, nil, nil, nil, nil, nil, nil, nil, nil, reset),
+		)
+	}
+
+	collector := newStatIOCollector()
+	resets := func() float64 {
+		for _, m := range collectMetrics(c, collector, server) {
+			if m.name == "pg_stat_io_resets_total" {
+				return m.value
+			}
+		}
+		c.Fatal("pg_stat_io_resets_total not emitted")
+		return 0
+	}
+
+	c.Assert(resets(), Equals, 0.0)
+	c.Assert(resets(), Equals, 0.0)
+	c.Assert(resets(), Equals, 1.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatIOSuite) TestStatIOUnsupportedVersion(c *C) {
+	server, mock := newMockServer(c, "15.4.0")
+	defer server.db.Close()
+
+	c.Assert(collectMetrics(c, newStatIOCollector(), server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatIOSuite) TestStatIOBackendFsyncMismatch(c *C) {
+	server, mock := newMockServer(c, "16.2.0")
+	defer server.db.Close()
+
+	reset := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(statIOQueryPrePG18).WillReturnRows(
+		sqlmock.NewRows(statIOColumns).
+			AddRow("client backend", "relation", "normal", nil, nil, nil, nil, nil, nil, nil, 4, nil, nil, nil, nil, nil, nil, nil, nil, reset).
+			AddRow("autovacuum worker", "relation", "normal", nil, nil, nil, nil, nil, nil, nil, 1, nil, nil, nil, nil, nil, nil, nil, nil, reset).
+			AddRow("checkpointer", "relation", "normal", nil, nil, nil, nil, nil, nil, nil, 250, nil, nil, nil, nil, nil, nil, nil, nil, reset),
+	)
+	mock.ExpectQuery(statIOBackendFsyncQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"buffers_backend_fsync"}).AddRow(5),
+	)
+
+	collector := newStatIOCollector().(*statIOCollector)
+	collector.backendFsyncMismatch = true
+
+	var mismatch []metricResult
+	for _, m := range collectMetrics(c, collector, server) {
+		if m.name == "pg_stat_io_backend_fsync_mismatch" {
+			mismatch = append(mismatch, m)
+		}
+	}
+
+	// The checkpointer fsyncs are not counted by buffers_backend_fsync.
+	c.Assert(mismatch, HasLen, 1)
+	c.Assert(mismatch[0].value, Equals, 0.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatIOSuite) TestStatIOExtendRate(c *C) {
+	server, mock := newMockServer(c, "17.0.0")
+	defer server.db.Close()
+
+	reset := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, extends := range []int{100, 400} {
+		mock.ExpectQuery(statIOQueryPrePG18).WillReturnRows(
+			sqlmock.NewRows(statIOColumns).
+				AddRow("client backend", "relation", "normal", nil, nil, nil, extends, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, reset).
+				AddRow("client backend", "relation", "bulkwrite", nil, nil, nil, 20, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, reset),
+		)
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	collector := newStatIOCollector().(*statIOCollector)
+	collector.now = func() time.Time { return now }
+
+	extendRate := func() []metricResult {
+		var results []metricResult
+		for _, m := range collectMetrics(c, collector, server) {
+			if m.name == "pg_stat_io_extend_rate" {
+				results = append(results, m)
+			}
+		}
+		return results
+	}
+
+	// The first scrape only sets the baseline.
+	c.Assert(extendRate(), HasLen, 0)
+
+	now = now.Add(15 * time.Second)
+	rates := extendRate()
+	c.Assert(rates, HasLen, 1)
+	c.Assert(rates[0].value, Equals, 20.0)
+	c.Assert(rates[0].labels, DeepEquals, map[string]string{
+		"server":       "test:5432",
+		"backend_type": "client backend",
+		"io_object":    "relation",
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatIOSuite) TestStatIOBulkwrite(c *C) {
+	server, mock := newMockServer(c, "17.0.0")
+	defer server.db.Close()
+
+	reset := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(statIOQueryPrePG18).WillReturnRows(
+		sqlmock.NewRows(statIOColumns).
+			AddRow("client backend", "relation", "bulkwrite", 5, 300, nil, 20, 10, 1, 40, nil, 40960, 2457600, 163840, nil, nil, nil, nil, nil, reset).
+			AddRow("client backend", "temp relation", "bulkwrite", nil, 100, nil, nil, nil, nil, nil, nil, nil, 819200, nil, nil, nil, nil, nil, nil, reset).
+			AddRow("client backend", "relation", "normal", 10, 5, 0, 2, 100, 1, nil, 3, 81920, 40960, 16384, nil, nil, nil, nil, nil, reset),
+	)
+
+	collector := newStatIOCollector().(*statIOCollector)
+	collector.bulkwrite = true
+
+	values := make(map[string]float64)
+	for _, m := range collectMetrics(c, collector, server) {
+		if strings.HasPrefix(m.name, "pg_stat_io_bulkwrite_") {
+			c.Assert(m.labels, DeepEquals, map[string]string{"server": "test:5432", "backend_type": "client backend"})
+			values[m.name] = m.value
+		}
+	}
+
+	// The columns which are NULL in every bulkwrite row are not reported.
+	c.Assert(values, DeepEquals, map[string]float64{
+		"pg_stat_io_bulkwrite_reads_total":        5,
+		"pg_stat_io_bulkwrite_writes_total":       400,
+		"pg_stat_io_bulkwrite_extends_total":      20,
+		"pg_stat_io_bulkwrite_hits_total":         10,
+		"pg_stat_io_bulkwrite_evictions_total":    1,
+		"pg_stat_io_bulkwrite_reuses_total":       40,
+		"pg_stat_io_bulkwrite_read_bytes_total":   40960,
+		"pg_stat_io_bulkwrite_write_bytes_total":  3276800,
+		"pg_stat_io_bulkwrite_extend_bytes_total": 163840,
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatIOSuite) TestStatIOGrandTotal(c *C) {
+	server, mock := newMockServer(c, "18.0.0")
+	defer server.db.Close()
+
+	reset := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(statIOQuery).WillReturnRows(
+		sqlmock.NewRows(statIOColumns).
+			AddRow("client backend", "relation", "normal", 10, 5, 0, 2, 100, 1, nil, 3, 81920, 40960, 16384, nil, nil, nil, nil, nil, reset).
+			AddRow("client backend", "relation", "bulkwrite", 5, 300, nil, 20, 10, 1, 40, nil, 40960, 2457600, 163840, nil, nil, nil, nil, nil, reset).
+			AddRow("checkpointer", "relation", "normal", nil, 1000, 1000, nil, nil, nil, nil, 50, nil, 8192000, nil, nil, nil, nil, nil, nil, reset).
+			AddRow("autovacuum worker", "relation", "vacuum", 7, 0, 0, 0, 30, 0, 12, nil, 57344, 0, 0, nil, nil, nil, nil, nil, reset),
+	)
+
+	collector := newStatIOCollector().(*statIOCollector)
+	collector.grandTotal = true
+
+	values := make(map[string]float64)
+	for _, m := range collectMetrics(c, collector, server) {
+		if strings.HasPrefix(m.name, "pg_stat_io_total_") {
+			c.Assert(m.labels, DeepEquals, map[string]string{"server": "test:5432"})
+			values[m.name] = m.value
+		}
+	}
+
+	// NULL values are left out of the sums.
+	c.Assert(values, DeepEquals, map[string]float64{
+		"pg_stat_io_total_reads":   22,
+		"pg_stat_io_total_writes":  1305,
+		"pg_stat_io_total_extends": 22,
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatIOSuite) TestStatIOAggregate(c *C) {
+	server, mock := newMockServer(c, "18.0.0")
+	defer server.db.Close()
+
+	reset := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(statIOColumns).
+			AddRow("client backend", "relation", "normal", 10, 5, 0, 2, 100, 1, nil, 3, 81920, 40960, 16384, nil, nil, nil, nil, nil, reset).
+			AddRow("client backend", "temp relation", "normal", 1, 1, nil, 1, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, reset).
+			AddRow("checkpointer", "relation", "normal", nil, 1000, 1000, nil, nil, nil, nil, 50, nil, 8192000, nil, nil, nil, nil, nil, nil, reset)
+	}
+	mock.ExpectQuery(statIOQuery).WillReturnRows(rows())
+	mock.ExpectQuery(statIOQuery).WillReturnRows(rows())
+
+	// Only the backend_type label is left.
+	collector := newStatIOCollector().(*statIOCollector)
+	collector.aggregate = statIOAggregateBackendType
+	values := make(map[string]float64)
+	for _, m := range collectMetrics(c, collector, server) {
+		if m.name == "pg_stat_io_reads_total" || m.name == "pg_stat_io_writes_total" || m.name == "pg_stat_io_reuses_total" {
+			c.Assert(m.labels, HasLen, 2)
+			values[m.name+"/"+m.labels["backend_type"]] = m.value
+		}
+	}
+	// NULL values are left out of the sums, and sums of NULL values only are
+	// not reported.
+	c.Assert(values, DeepEquals, map[string]float64{
+		"pg_stat_io_reads_total/client backend":  11,
+		"pg_stat_io_writes_total/client backend": 6,
+		"pg_stat_io_writes_total/checkpointer":   1000,
+	})
+
+	// No label is left.
+	collector = newStatIOCollector().(*statIOCollector)
+	collector.aggregate = statIOAggregateAll
+	values = make(map[string]float64)
+	for _, m := range collectMetrics(c, collector, server) {
+		if m.name == "pg_stat_io_reads_total" || m.name == "pg_stat_io_writes_total" {
+			c.Assert(m.labels, DeepEquals, map[string]string{"server": "test:5432"})
+			values[m.name] = m.value
+		}
+	}
+	c.Assert(values, DeepEquals, map[string]float64{
+		"pg_stat_io_reads_total":  11,
+		"pg_stat_io_writes_total": 1006,
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatIOSuite) TestStatIOAggregateExtendRateAndBulkwrite(c *C) {
+	server, mock := newMockServer(c, "17.0.0")
+	defer server.db.Close()
+
+	reset := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := func(extends, workerExtends, workerWrites int) *sqlmock.Rows {
+		return sqlmock.NewRows(statIOColumns).
+			AddRow("client backend", "relation", "normal", nil, nil, nil, extends, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, reset).
+			AddRow("client backend", "temp relation", "normal", nil, nil, nil, extends/10, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, reset).
+			AddRow("client backend", "relation", "bulkwrite", nil, 20, nil, 0, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, reset).
+			AddRow("autovacuum worker", "relation", "bulkwrite", nil, workerWrites, nil, workerExtends, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, reset)
+	}
+
+	for _, aggregate := range []string{statIOAggregateBackendType, statIOAggregateAll} {
+		mock.ExpectQuery(statIOQueryPrePG18).WillReturnRows(rows(100, 0, 50))
+		mock.ExpectQuery(statIOQueryPrePG18).WillReturnRows(rows(400, 15, 80))
+
+		now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		collector := newStatIOCollector().(*statIOCollector)
+		collector.aggregate = aggregate
+		collector.bulkwrite = true
+		collector.now = func() time.Time { return now }
+
+		collectMetrics(c, collector, server)
+		now = now.Add(15 * time.Second)
+		values := make(map[string]float64)
+		for _, m := range collectMetrics(c, collector, server) {
+			if m.name == "pg_stat_io_extend_rate" || m.name == "pg_stat_io_bulkwrite_writes_total" {
+				c.Assert(m.labels["io_object"], Equals, "", Commentf("%s", aggregate))
+				values[m.name+"/"+m.labels["backend_type"]] = m.value
+			}
+		}
+
+		if aggregate == statIOAggregateBackendType {
+			// (400 + 40 - 100 - 10) / 15, and 15 / 15.
+			c.Assert(values, DeepEquals, map[string]float64{
+				"pg_stat_io_extend_rate/client backend":               22,
+				"pg_stat_io_extend_rate/autovacuum worker":            1,
+				"pg_stat_io_bulkwrite_writes_total/client backend":    20,
+				"pg_stat_io_bulkwrite_writes_total/autovacuum worker": 80,
+			})
+			continue
+		}
+		// (400 + 40 + 15 - 100 - 10) / 15, without the backend_type label.
+		c.Assert(values, DeepEquals, map[string]float64{
+			"pg_stat_io_extend_rate/":            23,
+			"pg_stat_io_bulkwrite_writes_total/": 100,
+		})
+	}
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatIOSuite) TestStatIOSyncWriteRatio(c *C) {
+	server, mock := newMockServer(c, "18.0.0")
+	defer server.db.Close()
+
+	reset := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(statIOQuery).WillReturnRows(
+		sqlmock.NewRows(statIOColumns).
+			AddRow("client backend", "relation", "normal", 10, 150, 30, 2, 100, 1, nil, 3, 81920, 1228800, 16384, nil, nil, nil, nil, nil, reset).
+			AddRow("client backend", "temp relation", "normal", 5, 50, nil, 20, 10, 1, nil, nil, 40960, 409600, 163840, nil, nil, nil, nil, nil, reset).
+			AddRow("client backend", "wal", "normal", nil, 120, nil, nil, nil, nil, nil, 110, nil, 983040, nil, nil, nil, nil, nil, nil, reset).
+			AddRow("checkpointer", "relation", "normal", nil, 1000, 1000, nil, nil, nil, nil, 50, nil, 8192000, nil, nil, nil, nil, nil, nil, reset).
+			// No writes, no ratio.
+			AddRow("autovacuum worker", "relation", "vacuum", 7, 0, 0, 0, 30, 0, 12, nil, 57344, 0, 0, nil, nil, nil, nil, nil, reset),
+	)
+
+	collector := newStatIOCollector().(*statIOCollector)
+	collector.syncWriteRatio = true
+
+	values := make(map[string]float64)
+	for _, m := range collectMetrics(c, collector, server) {
+		if m.name == "pg_stat_io_sync_write_ratio" {
+			values[m.labels["backend_type"]] = m.value
+		}
+	}
+
+	// The WAL is left out: (30 + 3) / (150 + 50), and (1000 + 50) / 1000.
+	c.Assert(values, DeepEquals, map[string]float64{
+		"client backend": 0.165,
+		"checkpointer":   1.05,
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatIOSuite) TestStatIOWAL(c *C) {
+	server, mock := newMockServer(c, "18.0.0")
+	defer server.db.Close()
+
+	reset := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(statIOQuery).WillReturnRows(
+		sqlmock.NewRows(statIOColumns).
+			AddRow("client backend", "relation", "normal", 10, 5, 0, 2, 100, 1, nil, 3, 81920, 40960, 16384, nil, nil, nil, nil, nil, reset).
+			AddRow("client backend", "wal", "normal", nil, 120, nil, nil, nil, nil, nil, 110, nil, 983040, nil, nil, nil, nil, nil, nil, reset).
+			AddRow("walwriter", "wal", "normal", nil, 400, nil, nil, nil, nil, nil, 380, nil, 3276800, nil, nil, nil, nil, nil, nil, reset).
+			AddRow("startup", "wal", "init", nil, 0, nil, nil, nil, nil, nil, 0, nil, 0, nil, nil, nil, nil, nil, nil, reset),
+	)
+
+	values := make(map[string]float64)
+	for _, m := range collectMetrics(c, newStatIOCollector(), server) {
+		if strings.HasPrefix(m.name, "pg_stat_io_wal_") {
+			c.Assert(m.labels, DeepEquals, map[string]string{"server": "test:5432"})
+			values[m.name] = m.value
+		}
+	}
+
+	c.Assert(values, DeepEquals, map[string]float64{
+		"pg_stat_io_wal_writes_total": 520,
+		"pg_stat_io_wal_fsyncs_total": 490,
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatIOSuite) TestStatIOTimes(c *C) {
+	server, mock := newMockServer(c, "17.0.0")
+	defer server.db.Close()
+
+	reset := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(statIOQueryPrePG18).WillReturnRows(
+		sqlmock.NewRows(statIOColumns).
+			AddRow("client backend", "relation", "normal", 10, 5, 0, 2, 100, 1, nil, 3, 81920, 40960, 16384, 0.25, 0.5, 0, 0.125, 2.5, reset).
+			AddRow("client backend", "temp relation", "normal", 100, 50, nil, 20, 10, nil, nil, nil, 819200, 409600, 163840, 1.5, 3, nil, 0.75, nil, reset),
+	)
+
+	times := make(map[string]map[string]float64)
+	for _, m := range collectMetrics(c, newStatIOCollector(), server) {
+		if !strings.HasSuffix(m.name, "_time_seconds_total") {
+			continue
+		}
+		object := m.labels["io_object"]
+		if times[object] == nil {
+			times[object] = make(map[string]float64)
+		}
+		times[object][m.name] = m.value
+	}
+
+	// The spills of temp relations are reported apart from the relations.
+	c.Assert(times, DeepEquals, map[string]map[string]float64{
+		"relation": {
+			"pg_stat_io_read_time_seconds_total":      0.25,
+			"pg_stat_io_write_time_seconds_total":     0.5,
+			"pg_stat_io_writeback_time_seconds_total": 0,
+			"pg_stat_io_extend_time_seconds_total":    0.125,
+			"pg_stat_io_fsync_time_seconds_total":     2.5,
+		},
+		"temp relation": {
+			"pg_stat_io_read_time_seconds_total":   1.5,
+			"pg_stat_io_write_time_seconds_total":  3,
+			"pg_stat_io_extend_time_seconds_total": 0.75,
+		},
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatIOSuite) TestStatIOTimesWithoutTiming(c *C) {
+	server, mock := newMockServer(c, "17.0.0")
+	defer server.db.Close()
+
+	// The times are NULL with track_io_timing off.
+	reset := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(statIOQueryPrePG18).WillReturnRows(
+		sqlmock.NewRows(statIOColumns).
+			AddRow("client backend", "temp relation", "normal", 100, 50, nil, 20, 10, nil, nil, nil, 819200, 409600, 163840, nil, nil, nil, nil, nil, reset),
+	)
+
+	for _, m := range collectMetrics(c, newStatIOCollector(), server) {
+		c.Assert(strings.HasSuffix(m.name, "_time_seconds_total"), Equals, false, Commentf("metric %s", m.name))
+	}
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
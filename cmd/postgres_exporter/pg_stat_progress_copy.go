@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+func init() {
+	registerCollector("stat_progress_copy", defaultEnabled, everyDatabase, newStatProgressCopyCollector)
+}
+
+const statProgressCopySubsystem = "stat_progress_copy"
+
+var statProgressCopyLabels = []string{"datname", "relname", "command", "type", "pid"}
+
+// The view reports the COPY commands of all databases, but relid can only be
+// resolved in the database of the command, so each database reports its own.
+// COPY of a query has no relation, its relname is empty.
+const statProgressCopyQuery = `
+SELECT
+	current_database() AS datname,
+	COALESCE(c.relname, '') AS relname,
+	p.command,
+	p.type,
+	p.pid::text,
+	p.bytes_processed::float,
+	p.bytes_total::float,
+	p.tuples_processed::float,
+	p.tuples_excluded::float
+FROM pg_stat_progress_copy p
+LEFT JOIN pg_class c ON c.oid = p.relid
+WHERE p.datname = current_database()
+`
+
+// statProgressCopyGauges are the progress columns, in the order of the
+// columns of statProgressCopyQuery.
+var statProgressCopyGauges = []struct {
+	name, help string
+}{
+	{"bytes_processed", "Number of bytes already processed by the COPY command"},
+	{"bytes_total", "Size of the source file for COPY FROM, in bytes, or 0 if not available"},
+	{"tuples_processed", "Number of tuples already processed by the COPY command"},
+	{"tuples_excluded", "Number of tuples not processed because they were excluded by the WHERE clause of the COPY command"},
+}
+
+type statProgressCopyCollector struct{}
+
+func newStatProgressCopyCollector() Collector {
+	return &statProgressCopyCollector{}
+}
+
+// Update implements Collector.
+func (c *statProgressCopyCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if server.lastMapVersion.LT(semver.MustParse("14.0.0")) {
+		log.Debugf("Skipping pg_stat_progress_copy on %q: PostgreSQL 14 or newer is required", server)
+		return nil
+	}
+
+	rows, err := server.db.QueryContext(ctx, statProgressCopyQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	descs := make([]*prometheus.Desc, len(statProgressCopyGauges))
+	for i, gauge := range statProgressCopyGauges {
+		descs[i] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, statProgressCopySubsystem, gauge.name),
+			gauge.help, statProgressCopyLabels, server.labels,
+		)
+	}
+
+	for rows.Next() {
+		var (
+			datname, relname, command, copyType, pid string
+			values                                   = make([]float64, len(statProgressCopyGauges))
+		)
+		dest := []interface{}{&datname, &relname, &command, &copyType, &pid}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		for i, value := range values {
+			ch <- prometheus.MustNewConstMetric(descs[i], prometheus.GaugeValue, value, datname, relname, command, copyType, pid)
+		}
+	}
+
+	return rows.Err()
+}
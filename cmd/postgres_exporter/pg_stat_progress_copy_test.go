@@ -0,0 +1,56 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatProgressCopySuite struct{}
+
+var _ = Suite(&StatProgressCopySuite{})
+
+func (s *StatProgressCopySuite) TestStatProgressCopy(c *C) {
+	server, mock := newMockServer(c, "14.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statProgressCopyQuery).WillReturnRows(
+		sqlmock.NewRows([]string{
+			"datname", "relname", "command", "type", "pid",
+			"bytes_processed", "bytes_total", "tuples_processed", "tuples_excluded",
+		}).
+			AddRow("app", "events", "COPY FROM", "FILE", "4242", 1048576, 4194304, 10000, 12).
+			AddRow("app", "", "COPY TO", "PIPE", "4243", 2048, 0, 20, 0),
+	)
+
+	metrics := collectMetrics(c, newStatProgressCopyCollector(), server)
+
+	c.Assert(metrics, HasLen, 8)
+	c.Assert(metrics[0].name, Equals, "pg_stat_progress_copy_bytes_processed")
+	c.Assert(metrics[0].value, Equals, 1048576.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":  "test:5432",
+		"datname": "app",
+		"relname": "events",
+		"command": "COPY FROM",
+		"type":    "FILE",
+		"pid":     "4242",
+	})
+	c.Assert(metrics[1].name, Equals, "pg_stat_progress_copy_bytes_total")
+	c.Assert(metrics[1].value, Equals, 4194304.0)
+	c.Assert(metrics[3].name, Equals, "pg_stat_progress_copy_tuples_excluded")
+	c.Assert(metrics[3].value, Equals, 12.0)
+	c.Assert(metrics[6].labels["command"], Equals, "COPY TO")
+	c.Assert(metrics[6].labels["relname"], Equals, "")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatProgressCopySuite) TestStatProgressCopyBeforePG14(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	c.Assert(collectMetrics(c, newStatProgressCopyCollector(), server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
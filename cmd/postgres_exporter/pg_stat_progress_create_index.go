@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+func init() {
+	registerCollector("stat_progress_create_index", defaultEnabled, everyDatabase, newStatProgressCreateIndexCollector)
+}
+
+const statProgressCreateIndexSubsystem = "stat_progress_create_index"
+
+var statProgressCreateIndexLabels = []string{"datname", "relname", "phase", "command"}
+
+// The view reports the index builds of all databases, but relid can only be
+// resolved in the database of the build, so each database reports its own.
+// Builds of the same relation in the same phase are summed, as the labels
+// don't identify the backend.
+const statProgressCreateIndexQuery = `
+SELECT
+	current_database() AS datname,
+	c.relname,
+	p.phase,
+	p.command,
+	sum(p.blocks_total)::float AS blocks_total,
+	sum(p.blocks_done)::float AS blocks_done,
+	sum(p.tuples_total)::float AS tuples_total,
+	sum(p.tuples_done)::float AS tuples_done,
+	sum(p.lockers_total)::float AS lockers_total,
+	sum(p.lockers_done)::float AS lockers_done
+FROM pg_stat_progress_create_index p
+JOIN pg_class c ON c.oid = p.relid
+WHERE p.datname = current_database()
+GROUP BY c.relname, p.phase, p.command
+`
+
+// statProgressCreateIndexGauges are the progress columns, in the order of the
+// columns of statProgressCreateIndexQuery.
+var statProgressCreateIndexGauges = []struct {
+	name, help string
+}{
+	{"blocks_total", "Total number of blocks to be processed in the current phase of the index build"},
+	{"blocks_done", "Number of blocks already processed in the current phase of the index build"},
+	{"tuples_total", "Total number of tuples to be processed in the current phase of the index build"},
+	{"tuples_done", "Number of tuples already processed in the current phase of the index build"},
+	{"lockers_total", "Total number of lockers to wait for, when the index build waits for them"},
+	{"lockers_done", "Number of lockers already waited for by the index build"},
+}
+
+type statProgressCreateIndexCollector struct{}
+
+func newStatProgressCreateIndexCollector() Collector {
+	return &statProgressCreateIndexCollector{}
+}
+
+// Update implements Collector.
+func (c *statProgressCreateIndexCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if server.lastMapVersion.LT(semver.MustParse("12.0.0")) {
+		log.Debugf("Skipping pg_stat_progress_create_index on %q: PostgreSQL 12 or newer is required", server)
+		return nil
+	}
+
+	rows, err := server.db.QueryContext(ctx, statProgressCreateIndexQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	descs := make([]*prometheus.Desc, len(statProgressCreateIndexGauges))
+	for i, gauge := range statProgressCreateIndexGauges {
+		descs[i] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, statProgressCreateIndexSubsystem, gauge.name),
+			gauge.help, statProgressCreateIndexLabels, server.labels,
+		)
+	}
+
+	for rows.Next() {
+		var (
+			datname, relname, phase, command string
+			values                           = make([]float64, len(statProgressCreateIndexGauges))
+		)
+		dest := []interface{}{&datname, &relname, &phase, &command}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		for i, value := range values {
+			ch <- prometheus.MustNewConstMetric(descs[i], prometheus.GaugeValue, value, datname, relname, phase, command)
+		}
+	}
+
+	return rows.Err()
+}
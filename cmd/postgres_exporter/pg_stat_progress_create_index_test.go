@@ -0,0 +1,68 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatProgressCreateIndexSuite struct{}
+
+var _ = Suite(&StatProgressCreateIndexSuite{})
+
+func (s *StatProgressCreateIndexSuite) TestStatProgressCreateIndex(c *C) {
+	server, mock := newMockServer(c, "12.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statProgressCreateIndexQuery).WillReturnRows(
+		sqlmock.NewRows([]string{
+			"datname", "relname", "phase", "command",
+			"blocks_total", "blocks_done", "tuples_total", "tuples_done", "lockers_total", "lockers_done",
+		}).
+			AddRow("app", "events", "building index: scanning table", "CREATE INDEX CONCURRENTLY", 10000, 2500, 0, 0, 0, 0).
+			AddRow("app", "users", "waiting for old snapshots", "REINDEX CONCURRENTLY", 0, 0, 0, 0, 3, 1),
+	)
+
+	metrics := collectMetrics(c, newStatProgressCreateIndexCollector(), server)
+
+	c.Assert(metrics, HasLen, 12)
+	c.Assert(metrics[0].name, Equals, "pg_stat_progress_create_index_blocks_total")
+	c.Assert(metrics[0].value, Equals, 10000.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":  "test:5432",
+		"datname": "app",
+		"relname": "events",
+		"phase":   "building index: scanning table",
+		"command": "CREATE INDEX CONCURRENTLY",
+	})
+	c.Assert(metrics[1].name, Equals, "pg_stat_progress_create_index_blocks_done")
+	c.Assert(metrics[1].value, Equals, 2500.0)
+	c.Assert(metrics[10].name, Equals, "pg_stat_progress_create_index_lockers_total")
+	c.Assert(metrics[10].value, Equals, 3.0)
+	c.Assert(metrics[11].name, Equals, "pg_stat_progress_create_index_lockers_done")
+	c.Assert(metrics[11].labels["command"], Equals, "REINDEX CONCURRENTLY")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatProgressCreateIndexSuite) TestNoIndexBuild(c *C) {
+	server, mock := newMockServer(c, "16.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statProgressCreateIndexQuery).WillReturnRows(sqlmock.NewRows([]string{
+		"datname", "relname", "phase", "command",
+		"blocks_total", "blocks_done", "tuples_total", "tuples_done", "lockers_total", "lockers_done",
+	}))
+
+	c.Assert(collectMetrics(c, newStatProgressCreateIndexCollector(), server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatProgressCreateIndexSuite) TestStatProgressCreateIndexBeforePG12(c *C) {
+	server, mock := newMockServer(c, "11.0.0")
+	defer server.db.Close()
+
+	c.Assert(collectMetrics(c, newStatProgressCreateIndexCollector(), server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+func init() {
+	registerCollector("stat_replication", defaultEnabled, masterOnly, newStatReplicationCollector)
+}
+
+const statReplicationSubsystem = "stat_replication"
+
+var statReplicationLabels = []string{"application_name", "client_addr", "state"}
+
+// The current WAL position can't be queried during recovery, and the lag is
+// only meaningful on the primary, so a standby reports no row. The lag times
+// are NULL once a standby has caught up and is idle, they are reported as 0.
+const statReplicationQuery = `
+SELECT
+	COALESCE(application_name, '') AS application_name,
+	COALESCE(client_addr::text, '') AS client_addr,
+	COALESCE(state, '') AS state,
+	pg_wal_lsn_diff(pg_current_wal_lsn(), sent_lsn)::float AS sent_lag_bytes,
+	pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn)::float AS replay_lag_bytes,
+	COALESCE(EXTRACT(EPOCH FROM write_lag), 0)::float AS write_lag,
+	COALESCE(EXTRACT(EPOCH FROM flush_lag), 0)::float AS flush_lag,
+	COALESCE(EXTRACT(EPOCH FROM replay_lag), 0)::float AS replay_lag
+FROM pg_stat_replication
+WHERE NOT pg_is_in_recovery()
+`
+
+// Before PostgreSQL 10, the WAL functions and columns are named after xlog
+// and there are no lag times.
+const statReplicationQueryPre10 = `
+SELECT
+	COALESCE(application_name, '') AS application_name,
+	COALESCE(client_addr::text, '') AS client_addr,
+	COALESCE(state, '') AS state,
+	pg_xlog_location_diff(pg_current_xlog_location(), sent_location)::float AS sent_lag_bytes,
+	pg_xlog_location_diff(pg_current_xlog_location(), replay_location)::float AS replay_lag_bytes,
+	NULL::float AS write_lag,
+	NULL::float AS flush_lag,
+	NULL::float AS replay_lag
+FROM pg_stat_replication
+WHERE NOT pg_is_in_recovery()
+`
+
+type statReplicationCollector struct{}
+
+func newStatReplicationCollector() Collector {
+	return &statReplicationCollector{}
+}
+
+// Update implements Collector.
+func (c *statReplicationCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if server.lastMapVersion.LT(semver.MustParse("9.2.0")) {
+		log.Debugf("Skipping pg_stat_replication metrics on %q: PostgreSQL 9.2 or newer is required", server)
+		return nil
+	}
+
+	query := statReplicationQuery
+	if server.lastMapVersion.LT(semver.MustParse("10.0.0")) {
+		query = statReplicationQueryPre10
+	}
+
+	rows, err := server.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	sentLagBytesDesc := statReplicationDesc(server, "sent_lag_bytes", "Bytes of WAL not sent to this standby yet")
+	replayLagBytesDesc := statReplicationDesc(server, "replay_lag_bytes", "Bytes of WAL not replayed by this standby yet")
+	writeLagDesc := statReplicationDesc(server, "write_lag_seconds", "Time elapsed between flushing recent WAL locally and receiving notification that this standby has written it, in seconds")
+	flushLagDesc := statReplicationDesc(server, "flush_lag_seconds", "Time elapsed between flushing recent WAL locally and receiving notification that this standby has written and flushed it, in seconds")
+	replayLagDesc := statReplicationDesc(server, "replay_lag_seconds", "Time elapsed between flushing recent WAL locally and receiving notification that this standby has written, flushed and applied it, in seconds")
+
+	for rows.Next() {
+		var (
+			applicationName, clientAddr, state string
+			sentLagBytes, replayLagBytes       sql.NullFloat64
+			writeLag, flushLag, replayLag      sql.NullFloat64
+		)
+		if err := rows.Scan(&applicationName, &clientAddr, &state, &sentLagBytes, &replayLagBytes, &writeLag, &flushLag, &replayLag); err != nil {
+			return err
+		}
+
+		// The positions are NULL until the standby reported them, and the
+		// lag times before PostgreSQL 10.
+		for _, m := range []struct {
+			desc  *prometheus.Desc
+			value sql.NullFloat64
+		}{
+			{sentLagBytesDesc, sentLagBytes},
+			{replayLagBytesDesc, replayLagBytes},
+			{writeLagDesc, writeLag},
+			{flushLagDesc, flushLag},
+			{replayLagDesc, replayLag},
+		} {
+			if m.value.Valid {
+				ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, m.value.Float64, applicationName, clientAddr, state)
+			}
+		}
+	}
+
+	return rows.Err()
+}
+
+func statReplicationDesc(server *Server, name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, statReplicationSubsystem, name),
+		help, statReplicationLabels, server.labels,
+	)
+}
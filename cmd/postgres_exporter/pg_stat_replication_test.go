@@ -0,0 +1,76 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatReplicationSuite struct{}
+
+var _ = Suite(&StatReplicationSuite{})
+
+var statReplicationColumns = []string{
+	"application_name", "client_addr", "state",
+	"sent_lag_bytes", "replay_lag_bytes", "write_lag", "flush_lag", "replay_lag",
+}
+
+func (s *StatReplicationSuite) TestLag(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statReplicationQuery).WillReturnRows(
+		sqlmock.NewRows(statReplicationColumns).
+			AddRow("standby1", "10.0.0.2", "streaming", 0, 8192, 0.001, 0.002, 0.5).
+			AddRow("pg_basebackup", "10.0.0.3", "backup", 1024, nil, 0, 0, 0),
+	)
+
+	metrics := collectMetrics(c, newStatReplicationCollector(), server)
+
+	// No replay lag in bytes is reported before the standby replayed WAL.
+	c.Assert(metrics, HasLen, 9)
+	c.Assert(metrics[1].name, Equals, "pg_stat_replication_replay_lag_bytes")
+	c.Assert(metrics[1].value, Equals, 8192.0)
+	c.Assert(metrics[1].labels, DeepEquals, map[string]string{
+		"server":           "test:5432",
+		"application_name": "standby1",
+		"client_addr":      "10.0.0.2",
+		"state":            "streaming",
+	})
+	c.Assert(metrics[4].name, Equals, "pg_stat_replication_replay_lag_seconds")
+	c.Assert(metrics[4].value, Equals, 0.5)
+	c.Assert(metrics[5].name, Equals, "pg_stat_replication_sent_lag_bytes")
+	c.Assert(metrics[5].labels["application_name"], Equals, "pg_basebackup")
+	c.Assert(metrics[6].name, Equals, "pg_stat_replication_write_lag_seconds")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatReplicationSuite) TestNoLagTimesBeforePG10(c *C) {
+	server, mock := newMockServer(c, "9.6.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statReplicationQueryPre10).WillReturnRows(
+		sqlmock.NewRows(statReplicationColumns).
+			AddRow("standby1", "", "streaming", 0, 4096, nil, nil, nil),
+	)
+
+	metrics := collectMetrics(c, newStatReplicationCollector(), server)
+
+	c.Assert(metrics, HasLen, 2)
+	c.Assert(metrics[0].name, Equals, "pg_stat_replication_sent_lag_bytes")
+	c.Assert(metrics[1].name, Equals, "pg_stat_replication_replay_lag_bytes")
+	c.Assert(metrics[1].value, Equals, 4096.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatReplicationSuite) TestStandby(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statReplicationQuery).WillReturnRows(sqlmock.NewRows(statReplicationColumns))
+
+	c.Assert(collectMetrics(c, newStatReplicationCollector(), server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
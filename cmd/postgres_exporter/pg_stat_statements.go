@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	registerCollector("stat_statements", defaultDisabled, masterOnly, newStatStatementsCollector)
+}
+
+var statStatementsLimit = kingpin.Flag("collector.stat_statements.limit", "Number of queries reading the most blocks from disk reported by the stat_statements collector.").Default("10").Envar("PG_EXPORTER_STAT_STATEMENTS_LIMIT").Int()
+
+// The statements are summed over the users and databases which ran them, and
+// the queries which read the most blocks from disk come first.
+const statStatementsQuery = `
+SELECT
+	queryid::text AS queryid,
+	sum(shared_blks_hit)::float AS shared_blks_hit,
+	sum(shared_blks_read)::float AS shared_blks_read
+FROM pg_stat_statements
+WHERE queryid IS NOT NULL
+GROUP BY queryid
+ORDER BY sum(shared_blks_read) DESC
+LIMIT $1
+`
+
+type statStatementsCollector struct {
+	limit int
+}
+
+func newStatStatementsCollector() Collector {
+	return &statStatementsCollector{
+		limit: *statStatementsLimit,
+	}
+}
+
+// Update implements Collector. The pg_stat_statements extension must be
+// installed in the database of the DSN.
+func (c *statStatementsCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if server.lastMapVersion.LT(semver.MustParse("9.4.0")) {
+		log.Debugf("Skipping pg_stat_statements metrics on %q: PostgreSQL 9.4 or newer is required", server)
+		return nil
+	}
+
+	rows, err := server.db.QueryContext(ctx, statStatementsQuery, c.limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "stat_statements", "cache_hit_ratio"),
+		"Ratio of the shared blocks of the query found in the buffer cache, for the queries reading the most blocks from disk",
+		[]string{"queryid"}, server.labels,
+	)
+
+	for rows.Next() {
+		var (
+			queryid       string
+			hit, diskRead float64
+		)
+		if err := rows.Scan(&queryid, &hit, &diskRead); err != nil {
+			return err
+		}
+
+		// Queries which didn't access any shared block have no ratio.
+		if hit+diskRead == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, hit/(hit+diskRead), queryid)
+	}
+
+	return rows.Err()
+}
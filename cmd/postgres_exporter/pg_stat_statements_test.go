@@ -0,0 +1,35 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatStatementsSuite struct{}
+
+var _ = Suite(&StatStatementsSuite{})
+
+func (s *StatStatementsSuite) TestStatStatements(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statStatementsQuery).WithArgs(5).WillReturnRows(
+		sqlmock.NewRows([]string{"queryid", "shared_blks_hit", "shared_blks_read"}).
+			AddRow("-6145370386185406447", 750, 250).
+			AddRow("4213564532431887640", 0, 0),
+	)
+
+	metrics := collectMetrics(c, &statStatementsCollector{limit: 5}, server)
+
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].name, Equals, "pg_stat_statements_cache_hit_ratio")
+	c.Assert(metrics[0].value, Equals, 0.75)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":  "test:5432",
+		"queryid": "-6145370386185406447",
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+func init() {
+	registerCollector("stat_subscription", defaultEnabled, masterOnly, newStatSubscriptionCollector)
+}
+
+const statSubscriptionSubsystem = "stat_subscription"
+
+var statSubscriptionLabels = []string{"subname", "pid"}
+
+// A subscription has a row per worker, the apply worker and the table
+// synchronization workers, or a single one with NULL values if none is
+// running. The view reports the subscriptions of all databases.
+const statSubscriptionQuery = `
+SELECT
+	subname,
+	COALESCE(pid::text, '') AS pid,
+	EXTRACT(EPOCH FROM now() - last_msg_send_time)::float AS last_msg_send_age,
+	EXTRACT(EPOCH FROM now() - last_msg_receipt_time)::float AS last_msg_receipt_age,
+	pg_wal_lsn_diff(latest_end_lsn, '0/0')::float AS latest_end_lsn
+FROM pg_stat_subscription
+`
+
+// pg_stat_subscription_stats was added in PostgreSQL 15.
+const statSubscriptionStatsQuery = `
+SELECT
+	subname,
+	apply_error_count::float,
+	sync_error_count::float
+FROM pg_stat_subscription_stats
+`
+
+// statSubscriptionGauges are the columns of statSubscriptionQuery after the
+// labels. NULL values are not emitted.
+var statSubscriptionGauges = []struct {
+	name, help string
+}{
+	{"last_msg_send_age_seconds", "Time since the last message was sent by the publisher, in seconds"},
+	{"last_msg_receipt_age_seconds", "Time since the last message was received from the publisher, in seconds"},
+	{"latest_end_lsn", "Last WAL location reported to the publisher, in bytes"},
+}
+
+type statSubscriptionCollector struct{}
+
+func newStatSubscriptionCollector() Collector {
+	return &statSubscriptionCollector{}
+}
+
+// Update implements Collector.
+func (c *statSubscriptionCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if server.lastMapVersion.LT(semver.MustParse("10.0.0")) {
+		log.Debugf("Skipping pg_stat_subscription on %q: PostgreSQL 10 or newer is required", server)
+		return nil
+	}
+
+	if err := c.updateWorkers(ctx, server, ch); err != nil {
+		return err
+	}
+	if server.lastMapVersion.GE(semver.MustParse("15.0.0")) {
+		return c.updateStats(ctx, server, ch)
+	}
+	return nil
+}
+
+// updateWorkers emits the gauges of the workers of the subscriptions.
+func (c *statSubscriptionCollector) updateWorkers(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, statSubscriptionQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	descs := make([]*prometheus.Desc, len(statSubscriptionGauges))
+	for i, gauge := range statSubscriptionGauges {
+		descs[i] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, statSubscriptionSubsystem, gauge.name),
+			gauge.help, statSubscriptionLabels, server.labels,
+		)
+	}
+
+	for rows.Next() {
+		var (
+			subname, pid string
+			values       = make([]sql.NullFloat64, len(statSubscriptionGauges))
+		)
+		dest := []interface{}{&subname, &pid}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		for i, value := range values {
+			if value.Valid {
+				ch <- prometheus.MustNewConstMetric(descs[i], prometheus.GaugeValue, value.Float64, subname, pid)
+			}
+		}
+	}
+
+	return rows.Err()
+}
+
+// updateStats emits the error counters of the subscriptions.
+func (c *statSubscriptionCollector) updateStats(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, statSubscriptionStatsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	applyErrorsDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, statSubscriptionSubsystem, "apply_error_count"),
+		"Number of times an error occurred while applying changes",
+		[]string{"subname"}, server.labels,
+	)
+	syncErrorsDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, statSubscriptionSubsystem, "sync_error_count"),
+		"Number of times an error occurred during the initial table synchronization",
+		[]string{"subname"}, server.labels,
+	)
+
+	for rows.Next() {
+		var (
+			subname                 string
+			applyErrors, syncErrors float64
+		)
+		if err := rows.Scan(&subname, &applyErrors, &syncErrors); err != nil {
+			return err
+		}
+
+		ch <- prometheus.MustNewConstMetric(applyErrorsDesc, prometheus.CounterValue, applyErrors, subname)
+		ch <- prometheus.MustNewConstMetric(syncErrorsDesc, prometheus.CounterValue, syncErrors, subname)
+	}
+
+	return rows.Err()
+}
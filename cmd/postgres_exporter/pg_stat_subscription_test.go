@@ -0,0 +1,76 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatSubscriptionSuite struct{}
+
+var _ = Suite(&StatSubscriptionSuite{})
+
+var statSubscriptionColumns = []string{"subname", "pid", "last_msg_send_age", "last_msg_receipt_age", "latest_end_lsn"}
+
+func (s *StatSubscriptionSuite) TestStatSubscription(c *C) {
+	server, mock := newMockServer(c, "15.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statSubscriptionQuery).WillReturnRows(
+		sqlmock.NewRows(statSubscriptionColumns).
+			AddRow("orders_sub", "4242", 1.5, 1.25, 50331648).
+			// A subscription without a running worker.
+			AddRow("audit_sub", "", nil, nil, nil),
+	)
+	mock.ExpectQuery(statSubscriptionStatsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"subname", "apply_error_count", "sync_error_count"}).
+			AddRow("orders_sub", 3, 0).
+			AddRow("audit_sub", 0, 1),
+	)
+
+	metrics := collectMetrics(c, newStatSubscriptionCollector(), server)
+
+	c.Assert(metrics, HasLen, 7)
+	c.Assert(metrics[0].name, Equals, "pg_stat_subscription_last_msg_send_age_seconds")
+	c.Assert(metrics[0].value, Equals, 1.5)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":  "test:5432",
+		"subname": "orders_sub",
+		"pid":     "4242",
+	})
+	c.Assert(metrics[1].name, Equals, "pg_stat_subscription_last_msg_receipt_age_seconds")
+	c.Assert(metrics[1].value, Equals, 1.25)
+	c.Assert(metrics[2].name, Equals, "pg_stat_subscription_latest_end_lsn")
+	c.Assert(metrics[2].value, Equals, 50331648.0)
+	c.Assert(metrics[3].name, Equals, "pg_stat_subscription_apply_error_count")
+	c.Assert(metrics[3].value, Equals, 3.0)
+	c.Assert(metrics[3].labels, DeepEquals, map[string]string{
+		"server":  "test:5432",
+		"subname": "orders_sub",
+	})
+	c.Assert(metrics[6].name, Equals, "pg_stat_subscription_sync_error_count")
+	c.Assert(metrics[6].labels["subname"], Equals, "audit_sub")
+	c.Assert(metrics[6].value, Equals, 1.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatSubscriptionSuite) TestNoSubscriptions(c *C) {
+	server, mock := newMockServer(c, "14.0.0")
+	defer server.db.Close()
+
+	// Before PostgreSQL 15 there are no error counts.
+	mock.ExpectQuery(statSubscriptionQuery).WillReturnRows(sqlmock.NewRows(statSubscriptionColumns))
+
+	c.Assert(collectMetrics(c, newStatSubscriptionCollector(), server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatSubscriptionSuite) TestStatSubscriptionBeforePG10(c *C) {
+	server, mock := newMockServer(c, "9.6.0")
+	defer server.db.Close()
+
+	c.Assert(collectMetrics(c, newStatSubscriptionCollector(), server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+func init() {
+	registerCollector("stat_user_functions", defaultEnabled, everyDatabase, newStatUserFunctionsCollector)
+}
+
+const statUserFunctionsSubsystem = "stat_user_functions"
+
+var statUserFunctionsLabels = []string{"datname", "schemaname", "funcname"}
+
+// Functions are only tracked with track_functions set to pl or all. The
+// overloads of a function are summed up, they have the same labels. Times are
+// in milliseconds.
+const statUserFunctionsQuery = `
+SELECT
+	current_database() AS datname,
+	schemaname,
+	funcname,
+	sum(calls)::float AS calls,
+	sum(total_time)::float AS total_time,
+	sum(self_time)::float AS self_time
+FROM pg_stat_user_functions
+GROUP BY schemaname, funcname
+`
+
+type statUserFunctionsCollector struct{}
+
+func newStatUserFunctionsCollector() Collector {
+	return &statUserFunctionsCollector{}
+}
+
+// Update implements Collector.
+func (c *statUserFunctionsCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, statUserFunctionsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	callsDesc := statUserFunctionsDesc(server, "calls_total", "Number of times the function has been called")
+	totalTimeDesc := statUserFunctionsDesc(server, "total_time_seconds_total", "Time spent in the function and all other functions called by it, in seconds")
+	selfTimeDesc := statUserFunctionsDesc(server, "self_time_seconds_total", "Time spent in the function itself, not including other functions called by it, in seconds")
+	selfTimeRatioDesc := statUserFunctionsDesc(server, "self_time_ratio", "Fraction of the time of the function spent in the function itself rather than in the functions it calls")
+
+	functions := 0
+	for rows.Next() {
+		functions++
+		var (
+			datname, schemaname, funcname string
+			calls, totalTime, selfTime    float64
+		)
+		if err := rows.Scan(&datname, &schemaname, &funcname, &calls, &totalTime, &selfTime); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(callsDesc, prometheus.CounterValue, calls, datname, schemaname, funcname)
+		ch <- prometheus.MustNewConstMetric(totalTimeDesc, prometheus.CounterValue, totalTime/1000, datname, schemaname, funcname)
+		ch <- prometheus.MustNewConstMetric(selfTimeDesc, prometheus.CounterValue, selfTime/1000, datname, schemaname, funcname)
+		// The ratio is undefined until the function took measurable time.
+		if totalTime > 0 {
+			ch <- prometheus.MustNewConstMetric(selfTimeRatioDesc, prometheus.GaugeValue, selfTime/totalTime, datname, schemaname, funcname)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if functions == 0 {
+		log.Debugf("No function statistics on %q, track_functions may be off", server)
+	}
+	return nil
+}
+
+func statUserFunctionsDesc(server *Server, name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, statUserFunctionsSubsystem, name),
+		help, statUserFunctionsLabels, server.labels,
+	)
+}
@@ -0,0 +1,54 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatUserFunctionsSuite struct{}
+
+var _ = Suite(&StatUserFunctionsSuite{})
+
+func (s *StatUserFunctionsSuite) TestSelfTimeRatio(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statUserFunctionsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "schemaname", "funcname", "calls", "total_time", "self_time"}).
+			AddRow("app", "public", "wrapper", 10, 2000, 500).
+			AddRow("app", "public", "never_timed", 3, 0, 0),
+	)
+
+	metrics := collectMetrics(c, newStatUserFunctionsCollector(), server)
+
+	// No ratio is reported for a function which took no time.
+	c.Assert(metrics, HasLen, 7)
+	c.Assert(metrics[1].name, Equals, "pg_stat_user_functions_total_time_seconds_total")
+	c.Assert(metrics[1].value, Equals, 2.0)
+	c.Assert(metrics[3].name, Equals, "pg_stat_user_functions_self_time_ratio")
+	c.Assert(metrics[3].value, Equals, 0.25)
+	c.Assert(metrics[3].labels, DeepEquals, map[string]string{
+		"server":     "test:5432",
+		"datname":    "app",
+		"schemaname": "public",
+		"funcname":   "wrapper",
+	})
+	c.Assert(metrics[6].labels["funcname"], Equals, "never_timed")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *StatUserFunctionsSuite) TestNoFunctions(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	// The view is empty with track_functions off.
+	mock.ExpectQuery(statUserFunctionsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "schemaname", "funcname", "calls", "total_time", "self_time"}),
+	)
+
+	c.Assert(collectMetrics(c, newStatUserFunctionsCollector(), server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	registerCollector("stat_user_indexes", defaultDisabled, everyDatabase, newStatUserIndexesCollector)
+}
+
+var statUserIndexesMinSize = kingpin.Flag("collector.stat_user_indexes.min-size-bytes", "Minimum size of the indexes reported by the stat_user_indexes collector, in bytes.").Default("0").Envar("PG_EXPORTER_STAT_USER_INDEXES_MIN_SIZE_BYTES").Int64()
+
+const statUserIndexesSubsystem = "stat_user_indexes"
+
+var statUserIndexesLabels = []string{"datname", "schemaname", "relname", "indexrelname"}
+
+// Indexes smaller than $1 bytes are skipped, to limit the number of series on
+// schemas with many indexes.
+const statUserIndexesQuery = `
+SELECT
+	current_database() AS datname,
+	s.schemaname,
+	s.relname,
+	s.indexrelname,
+	s.idx_scan,
+	s.idx_tup_read,
+	s.idx_tup_fetch,
+	COALESCE(io.idx_blks_read, 0) AS idx_blks_read,
+	COALESCE(io.idx_blks_hit, 0) AS idx_blks_hit,
+	pg_relation_size(s.indexrelid) AS size_bytes
+FROM pg_stat_user_indexes s
+	JOIN pg_statio_user_indexes io ON io.indexrelid = s.indexrelid
+WHERE pg_relation_size(s.indexrelid) >= $1
+`
+
+// statUserIndexesCounters are the counters of statUserIndexesQuery, in the
+// order of its columns.
+var statUserIndexesCounters = []struct {
+	name, help string
+}{
+	{"idx_scan", "Number of index scans initiated on this index"},
+	{"idx_tup_read", "Number of index entries returned by scans on this index"},
+	{"idx_tup_fetch", "Number of live table rows fetched by simple index scans using this index"},
+	{"idx_blks_read", "Number of disk blocks read from this index"},
+	{"idx_blks_hit", "Number of buffer hits in this index"},
+}
+
+type statUserIndexesCollector struct {
+	minSize int64
+}
+
+func newStatUserIndexesCollector() Collector {
+	return &statUserIndexesCollector{minSize: *statUserIndexesMinSize}
+}
+
+// Update implements Collector.
+func (c *statUserIndexesCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, statUserIndexesQuery, c.minSize)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	descs := make([]*prometheus.Desc, len(statUserIndexesCounters))
+	for i, counter := range statUserIndexesCounters {
+		descs[i] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, statUserIndexesSubsystem, counter.name),
+			counter.help, statUserIndexesLabels, server.labels,
+		)
+	}
+	sizeDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, statUserIndexesSubsystem, "size_bytes"),
+		"Disk space used by this index, in bytes", statUserIndexesLabels, server.labels,
+	)
+
+	for rows.Next() {
+		var (
+			datname, schemaname, relname, indexrelname string
+			values                                     = make([]float64, len(statUserIndexesCounters))
+			size                                       float64
+		)
+
+		dest := []interface{}{&datname, &schemaname, &relname, &indexrelname}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		dest = append(dest, &size)
+
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		for i, value := range values {
+			ch <- prometheus.MustNewConstMetric(descs[i], prometheus.CounterValue, value, datname, schemaname, relname, indexrelname)
+		}
+		ch <- prometheus.MustNewConstMetric(sizeDesc, prometheus.GaugeValue, size, datname, schemaname, relname, indexrelname)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,46 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type StatUserIndexesSuite struct{}
+
+var _ = Suite(&StatUserIndexesSuite{})
+
+func (s *StatUserIndexesSuite) TestStatUserIndexes(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(statUserIndexesQuery).WithArgs(int64(8192)).WillReturnRows(
+		sqlmock.NewRows([]string{
+			"datname", "schemaname", "relname", "indexrelname",
+			"idx_scan", "idx_tup_read", "idx_tup_fetch", "idx_blks_read", "idx_blks_hit", "size_bytes",
+		}).
+			AddRow("app", "public", "orders", "orders_pkey", 1500, 3000, 2900, 40, 9000, 65536).
+			AddRow("app", "public", "orders", "orders_unused_idx", 0, 0, 0, 0, 0, 1048576),
+	)
+
+	metrics := collectMetrics(c, &statUserIndexesCollector{minSize: 8192}, server)
+
+	c.Assert(metrics, HasLen, 12)
+	c.Assert(metrics[0].name, Equals, "pg_stat_user_indexes_idx_scan")
+	c.Assert(metrics[0].value, Equals, 1500.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":       "test:5432",
+		"datname":      "app",
+		"schemaname":   "public",
+		"relname":      "orders",
+		"indexrelname": "orders_pkey",
+	})
+	c.Assert(metrics[4].name, Equals, "pg_stat_user_indexes_idx_blks_hit")
+	c.Assert(metrics[4].value, Equals, 9000.0)
+	c.Assert(metrics[5].name, Equals, "pg_stat_user_indexes_size_bytes")
+	c.Assert(metrics[6].labels["indexrelname"], Equals, "orders_unused_idx")
+	c.Assert(metrics[6].value, Equals, 0.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
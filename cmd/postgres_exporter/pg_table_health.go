@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	registerCollector("table_health", defaultDisabled, everyDatabase, newTableHealthCollector)
+}
+
+var (
+	tableHealthDeadTuplesWeight = kingpin.Flag("collector.table_health.dead-tuples-weight", "Weight of the dead tuples ratio in the table health score.").Default("1").Envar("PG_EXPORTER_TABLE_HEALTH_DEAD_TUPLES_WEIGHT").Float64()
+	tableHealthSeqScanWeight    = kingpin.Flag("collector.table_health.seq-scan-weight", "Weight of the sequential scans ratio in the table health score.").Default("1").Envar("PG_EXPORTER_TABLE_HEALTH_SEQ_SCAN_WEIGHT").Float64()
+	tableHealthStaleStatsWeight = kingpin.Flag("collector.table_health.stale-stats-weight", "Weight of the rows modified since the last analyze in the table health score.").Default("1").Envar("PG_EXPORTER_TABLE_HEALTH_STALE_STATS_WEIGHT").Float64()
+)
+
+// idx_scan is NULL for tables without indexes.
+const tableHealthQuery = `
+SELECT
+	current_database() AS datname,
+	schemaname,
+	relname,
+	n_live_tup::float,
+	n_dead_tup::float,
+	seq_scan::float,
+	COALESCE(idx_scan, 0)::float AS idx_scan,
+	n_mod_since_analyze::float
+FROM pg_stat_user_tables
+`
+
+// tableHealthStats are the columns of tableHealthQuery the score is computed
+// from.
+type tableHealthStats struct {
+	liveTuples, deadTuples float64
+	seqScans, idxScans     float64
+	modSinceAnalyze        float64
+}
+
+type tableHealthCollector struct {
+	deadTuplesWeight float64
+	seqScanWeight    float64
+	staleStatsWeight float64
+}
+
+func newTableHealthCollector() Collector {
+	return &tableHealthCollector{
+		deadTuplesWeight: *tableHealthDeadTuplesWeight,
+		seqScanWeight:    *tableHealthSeqScanWeight,
+		staleStatsWeight: *tableHealthStaleStatsWeight,
+	}
+}
+
+// Update implements Collector.
+func (c *tableHealthCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if c.deadTuplesWeight < 0 || c.seqScanWeight < 0 || c.staleStatsWeight < 0 || c.deadTuplesWeight+c.seqScanWeight+c.staleStatsWeight == 0 {
+		return errors.New("the weights of the table health score must not be negative, and one of them must not be zero")
+	}
+	if server.lastMapVersion.LT(semver.MustParse("9.4.0")) {
+		log.Debugf("Skipping table health on %q: PostgreSQL 9.4 or newer is required", server)
+		return nil
+	}
+
+	rows, err := server.db.QueryContext(ctx, tableHealthQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "table", "health_score"),
+		"Health of the table from 0 (worst) to 1, the weighted mean of its dead tuples ratio, sequential scans ratio and rows modified since the last analyze ratio, subtracted from 1",
+		[]string{"datname", "schemaname", "relname"}, server.labels,
+	)
+
+	for rows.Next() {
+		var (
+			datname, schemaname, relname string
+			stats                        tableHealthStats
+		)
+		if err := rows.Scan(&datname, &schemaname, &relname, &stats.liveTuples, &stats.deadTuples, &stats.seqScans, &stats.idxScans, &stats.modSinceAnalyze); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, c.score(stats), datname, schemaname, relname)
+	}
+
+	return rows.Err()
+}
+
+// score returns 1 minus the weighted mean of three ratios, each capped to 1,
+// and 0 if both of its terms are 0:
+//   - the dead tuples ratio, n_dead_tup / (n_live_tup + n_dead_tup),
+//   - the sequential scans ratio, seq_scan / (seq_scan + idx_scan),
+//   - the stale statistics ratio, n_mod_since_analyze / n_live_tup.
+func (c *tableHealthCollector) score(stats tableHealthStats) float64 {
+	ratio := func(part, total float64) float64 {
+		if total <= 0 {
+			if part > 0 {
+				return 1
+			}
+			return 0
+		}
+		return math.Min(part/total, 1)
+	}
+
+	weighted := c.deadTuplesWeight*ratio(stats.deadTuples, stats.liveTuples+stats.deadTuples) +
+		c.seqScanWeight*ratio(stats.seqScans, stats.seqScans+stats.idxScans) +
+		c.staleStatsWeight*ratio(stats.modSinceAnalyze, stats.liveTuples)
+	return 1 - weighted/(c.deadTuplesWeight+c.seqScanWeight+c.staleStatsWeight)
+}
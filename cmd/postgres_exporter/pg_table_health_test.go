@@ -0,0 +1,63 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"context"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	. "gopkg.in/check.v1"
+)
+
+type TableHealthSuite struct{}
+
+var _ = Suite(&TableHealthSuite{})
+
+func (s *TableHealthSuite) TestTableHealth(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+	server.collectorConfig = newCollectorConfig([]string{"scratch"})
+
+	mock.ExpectQuery(tableHealthQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "schemaname", "relname", "n_live_tup", "n_dead_tup", "seq_scan", "idx_scan", "n_mod_since_analyze"}).
+			// Healthy: no dead tuples, index scans only, analyzed.
+			AddRow("postgres", "public", "users", 1000, 0, 0, 500, 0).
+			// 1/4 dead tuples, 1/2 sequential scans, 1/5 of the rows modified.
+			AddRow("postgres", "public", "orders", 3000, 1000, 50, 50, 600).
+			// Empty and never scanned.
+			AddRow("postgres", "public", "empty", 0, 0, 0, 0, 0).
+			// No live tuples left, only sequential scans.
+			AddRow("postgres", "audit", "log", 0, 200, 10, 0, 200).
+			// In an excluded database.
+			AddRow("scratch", "public", "users", 1000, 0, 0, 500, 0),
+	)
+
+	collector := &tableHealthCollector{deadTuplesWeight: 2, seqScanWeight: 1, staleStatsWeight: 1}
+	metrics := collectMetrics(c, collector, server)
+
+	c.Assert(metrics, HasLen, 4)
+	c.Assert(metrics[0].name, Equals, "pg_table_health_score")
+	c.Assert(metrics[0].value, Equals, 1.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":     "test:5432",
+		"datname":    "postgres",
+		"schemaname": "public",
+		"relname":    "users",
+	})
+	// 1 - (2*0.25 + 1*0.5 + 1*0.2) / 4
+	c.Assert(metrics[1].value, Equals, 0.7)
+	c.Assert(metrics[2].value, Equals, 1.0)
+	c.Assert(metrics[3].value, Equals, 0.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *TableHealthSuite) TestInvalidWeights(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	collector := &tableHealthCollector{}
+	c.Assert(collector.Update(context.Background(), server, make(chan prometheus.Metric)), ErrorMatches, "the weights of the table health score .*")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	registerCollector("triggers", defaultDisabled, everyDatabase, newTriggersCollector)
+}
+
+var triggersExcludeSchemas = kingpin.Flag("collector.triggers.exclude-schemas", "A comma-separated list of schemas to skip in the triggers collector.").Default("").Envar("PG_EXPORTER_TRIGGERS_EXCLUDE_SCHEMAS").String()
+
+// The internal triggers implement the foreign key constraints, only those
+// created by users are counted.
+const triggersQuery = `
+SELECT
+	current_database() AS datname,
+	n.nspname AS schemaname,
+	c.relname,
+	count(*)::float AS triggers
+FROM pg_trigger t
+	JOIN pg_class c ON c.oid = t.tgrelid
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE NOT t.tgisinternal
+	AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+GROUP BY n.nspname, c.relname
+`
+
+type triggersCollector struct {
+	excludeSchemas []string
+}
+
+func newTriggersCollector() Collector {
+	var excludeSchemas []string
+	for _, schema := range strings.Split(*triggersExcludeSchemas, ",") {
+		if schema = strings.TrimSpace(schema); schema != "" {
+			excludeSchemas = append(excludeSchemas, schema)
+		}
+	}
+	return &triggersCollector{excludeSchemas: excludeSchemas}
+}
+
+// Update implements Collector.
+func (c *triggersCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, triggersQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "table", "triggers_count"),
+		"Number of triggers of the table, excluding the internal ones of the constraints",
+		[]string{"datname", "schemaname", "relname"}, server.labels,
+	)
+
+	for rows.Next() {
+		var (
+			datname, schemaname, relname string
+			triggers                     float64
+		)
+		if err := rows.Scan(&datname, &schemaname, &relname, &triggers); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) || contains(c.excludeSchemas, schemaname) {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, triggers, datname, schemaname, relname)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,40 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type TriggersSuite struct{}
+
+var _ = Suite(&TriggersSuite{})
+
+func (s *TriggersSuite) TestTriggers(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+	server.collectorConfig = newCollectorConfig([]string{"scratch"})
+
+	mock.ExpectQuery(triggersQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "schemaname", "relname", "triggers"}).
+			AddRow("postgres", "public", "orders", 2).
+			AddRow("postgres", "audit", "events", 1).
+			AddRow("scratch", "public", "orders", 1),
+	)
+
+	collector := &triggersCollector{excludeSchemas: []string{"audit"}}
+	metrics := collectMetrics(c, collector, server)
+
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].name, Equals, "pg_table_triggers_count")
+	c.Assert(metrics[0].value, Equals, 2.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{
+		"server":     "test:5432",
+		"datname":    "postgres",
+		"schemaname": "public",
+		"relname":    "orders",
+	})
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
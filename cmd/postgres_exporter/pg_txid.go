@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	registerCollector("txid", defaultEnabled, everyDatabase, newTxidCollector)
+}
+
+var txidOldestTables = kingpin.Flag("collector.txid.oldest-tables", "Number of tables with the oldest relfrozenxid reported per database by the txid collector, 0 disables them.").Default("10").Envar("PG_EXPORTER_TXID_OLDEST_TABLES").Int()
+
+// txid_current() would assign a transaction ID on every scrape, and fails on
+// a standby, so the next transaction ID is read from a snapshot instead. The
+// functions were renamed in PostgreSQL 13.
+const (
+	txidCurrentQuery      = `SELECT pg_snapshot_xmax(pg_current_snapshot())::text::float`
+	txidCurrentQueryPre13 = `SELECT txid_snapshot_xmax(txid_current_snapshot())::float`
+)
+
+// TOAST tables are frozen separately from their table, so they are included.
+const txidOldestTablesQuery = `
+SELECT
+	current_database() AS datname,
+	n.nspname AS schemaname,
+	c.relname,
+	age(c.relfrozenxid) AS relfrozenxid_age
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'm', 't')
+ORDER BY age(c.relfrozenxid) DESC
+LIMIT $1
+`
+
+type txidCollector struct {
+	oldestTables int
+}
+
+func newTxidCollector() Collector {
+	return &txidCollector{
+		oldestTables: *txidOldestTables,
+	}
+}
+
+// Update implements Collector.
+func (c *txidCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	// The transaction IDs are shared by all the databases of the instance.
+	if server.master {
+		if err := c.updateCurrent(ctx, server, ch); err != nil {
+			return err
+		}
+	}
+
+	if c.oldestTables <= 0 {
+		return nil
+	}
+	return c.updateOldestTables(ctx, server, ch)
+}
+
+func (c *txidCollector) updateCurrent(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	query := txidCurrentQuery
+	if server.lastMapVersion.LT(semver.MustParse("13.0.0")) {
+		query = txidCurrentQueryPre13
+	}
+
+	var current float64
+	if err := server.db.QueryRowContext(ctx, query).Scan(&current); err != nil {
+		return err
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		newDesc("txid", "current", "Next transaction ID to be assigned, including the epoch", server.labels),
+		prometheus.CounterValue, current,
+	)
+	return nil
+}
+
+func (c *txidCollector) updateOldestTables(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	rows, err := server.db.QueryContext(ctx, txidOldestTablesQuery, c.oldestTables)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "class", "relfrozenxid_age"),
+		"Age of the oldest unfrozen transaction ID in the table, in transactions, for the tables with the oldest ones",
+		[]string{"datname", "schemaname", "relname"}, server.labels,
+	)
+
+	for rows.Next() {
+		var (
+			datname, schemaname, relname string
+			age                          float64
+		)
+		if err := rows.Scan(&datname, &schemaname, &relname, &age); err != nil {
+			return err
+		}
+
+		if server.collectorConfig.isExcluded(datname) {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, age, datname, schemaname, relname)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,62 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	. "gopkg.in/check.v1"
+)
+
+type TxidSuite struct{}
+
+var _ = Suite(&TxidSuite{})
+
+func (s *TxidSuite) TestTxid(c *C) {
+	server, mock := newMockServer(c, "13.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(txidCurrentQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"pg_snapshot_xmax"}).AddRow(4294968296),
+	)
+	mock.ExpectQuery(txidOldestTablesQuery).WithArgs(2).WillReturnRows(
+		sqlmock.NewRows([]string{"datname", "schemaname", "relname", "relfrozenxid_age"}).
+			AddRow("app", "public", "events", 180000000).
+			AddRow("app", "pg_toast", "pg_toast_16384", 150000000),
+	)
+
+	metrics := collectMetrics(c, &txidCollector{oldestTables: 2}, server)
+
+	c.Assert(metrics, HasLen, 3)
+	c.Assert(metrics[0].name, Equals, "pg_txid_current")
+	c.Assert(metrics[0].value, Equals, 4294968296.0)
+	c.Assert(metrics[1].name, Equals, "pg_class_relfrozenxid_age")
+	c.Assert(metrics[1].value, Equals, 180000000.0)
+	c.Assert(metrics[1].labels, DeepEquals, map[string]string{
+		"server":     "test:5432",
+		"datname":    "app",
+		"schemaname": "public",
+		"relname":    "events",
+	})
+	c.Assert(metrics[2].labels["relname"], Equals, "pg_toast_16384")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *TxidSuite) TestTxidPre13NotMaster(c *C) {
+	server, mock := newMockServer(c, "12.0.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(txidCurrentQueryPre13).WillReturnRows(
+		sqlmock.NewRows([]string{"txid_snapshot_xmax"}).AddRow(1000),
+	)
+
+	// The oldest tables can be disabled.
+	metrics := collectMetrics(c, &txidCollector{}, server)
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].value, Equals, 1000.0)
+
+	// The current transaction ID is only reported once per instance.
+	server.master = false
+	c.Assert(collectMetrics(c, &txidCollector{}, server), HasLen, 0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
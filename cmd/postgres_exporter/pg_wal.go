@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/blang/semver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+func init() {
+	registerCollector("wal", defaultEnabled, masterOnly, newWALCollector)
+}
+
+const (
+	walSubsystem     = "wal"
+	statWALSubsystem = "stat_wal"
+)
+
+// The WAL position is reported as the number of bytes since 0/0, which only
+// grows, so the rate of WAL generation is its rate. As for replication slots
+// the last received position is used during recovery.
+const walQuery = `
+SELECT
+	pg_wal_lsn_diff(CASE WHEN pg_is_in_recovery() THEN pg_last_wal_receive_lsn() ELSE pg_current_wal_lsn() END, '0/0')::float AS bytes
+`
+
+// pg_ls_waldir() requires superuser or pg_monitor, so the segments are counted
+// by a query of their own, which may fail without the WAL position.
+const walSegmentsQuery = `
+SELECT count(*) AS segments FROM pg_ls_waldir() WHERE name ~ '^[0-9A-F]{24}$'
+`
+
+// pg_stat_wal was added in PostgreSQL 14.
+const statWALQuery = `
+SELECT
+	wal_records,
+	wal_fpi,
+	wal_bytes,
+	wal_buffers_full
+FROM pg_stat_wal
+`
+
+// statWALCounters are the counters of pg_stat_wal, in the order of the
+// columns selected by statWALQuery.
+var statWALCounters = []struct {
+	name, help string
+}{
+	{"records_total", "Number of WAL records generated"},
+	{"fpi_total", "Number of WAL full page images generated"},
+	{"bytes_total", "Amount of WAL generated, in bytes"},
+	{"buffers_full_total", "Number of times WAL data was written to disk because WAL buffers became full"},
+}
+
+type walCollector struct{}
+
+func newWALCollector() Collector {
+	return &walCollector{}
+}
+
+// Update implements Collector.
+func (c *walCollector) Update(ctx context.Context, server *Server, ch chan<- prometheus.Metric) error {
+	if server.lastMapVersion.LT(semver.MustParse("10.0.0")) {
+		log.Debugf("Skipping WAL metrics on %q: PostgreSQL 10 or newer is required", server)
+		return nil
+	}
+
+	var bytes sql.NullFloat64
+	if err := server.db.QueryRowContext(ctx, walQuery).Scan(&bytes); err != nil {
+		return err
+	}
+
+	if bytes.Valid {
+		ch <- prometheus.MustNewConstMetric(
+			newDesc(walSubsystem, "bytes_total", "WAL position, in bytes since 0/0", server.labels),
+			prometheus.CounterValue, bytes.Float64,
+		)
+	}
+
+	var segments float64
+	err := server.db.QueryRowContext(ctx, walSegmentsQuery).Scan(&segments)
+	switch {
+	case isPermissionDenied(err):
+		log.Debugf("Skipping WAL segments on %q: %v", server, err)
+	case err != nil:
+		return err
+	default:
+		ch <- prometheus.MustNewConstMetric(
+			newDesc(walSubsystem, "segments", "Number of WAL segment files in pg_wal", server.labels),
+			prometheus.GaugeValue, segments,
+		)
+	}
+
+	if server.lastMapVersion.LT(semver.MustParse("14.0.0")) {
+		return nil
+	}
+
+	values := make([]float64, len(statWALCounters))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := server.db.QueryRowContext(ctx, statWALQuery).Scan(dest...); err != nil {
+		return err
+	}
+
+	for i, counter := range statWALCounters {
+		ch <- prometheus.MustNewConstMetric(
+			newDesc(statWALSubsystem, counter.name, counter.help, server.labels),
+			prometheus.CounterValue, values[i],
+		)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	. "gopkg.in/check.v1"
+)
+
+type WALSuite struct{}
+
+var _ = Suite(&WALSuite{})
+
+func (s *WALSuite) TestWAL(c *C) {
+	server, mock := newMockServer(c, "14.1.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(walQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"bytes"}).AddRow(1073741824),
+	)
+	mock.ExpectQuery(walSegmentsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"segments"}).AddRow(12),
+	)
+	mock.ExpectQuery(statWALQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"wal_records", "wal_fpi", "wal_bytes", "wal_buffers_full"}).AddRow(5000, 300, 2097152, 7),
+	)
+
+	metrics := collectMetrics(c, newWALCollector(), server)
+
+	c.Assert(metrics, HasLen, 6)
+	c.Assert(metrics[0].name, Equals, "pg_wal_bytes_total")
+	c.Assert(metrics[0].value, Equals, 1073741824.0)
+	c.Assert(metrics[0].labels, DeepEquals, map[string]string{"server": "test:5432"})
+	c.Assert(metrics[1].name, Equals, "pg_wal_segments")
+	c.Assert(metrics[1].value, Equals, 12.0)
+	c.Assert(metrics[4].name, Equals, "pg_stat_wal_bytes_total")
+	c.Assert(metrics[4].value, Equals, 2097152.0)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *WALSuite) TestWALWithoutStatWAL(c *C) {
+	server, mock := newMockServer(c, "13.3.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(walQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"bytes"}).AddRow(nil),
+	)
+	mock.ExpectQuery(walSegmentsQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"segments"}).AddRow(3),
+	)
+
+	metrics := collectMetrics(c, newWALCollector(), server)
+
+	c.Assert(metrics, HasLen, 1)
+	c.Assert(metrics[0].name, Equals, "pg_wal_segments")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *WALSuite) TestWALSegmentsDenied(c *C) {
+	server, mock := newMockServer(c, "14.1.0")
+	defer server.db.Close()
+
+	mock.ExpectQuery(walQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"bytes"}).AddRow(1073741824),
+	)
+	mock.ExpectQuery(walSegmentsQuery).WillReturnError(
+		&pq.Error{Code: "42501", Message: "permission denied for function pg_ls_waldir"},
+	)
+	mock.ExpectQuery(statWALQuery).WillReturnRows(
+		sqlmock.NewRows([]string{"wal_records", "wal_fpi", "wal_bytes", "wal_buffers_full"}).AddRow(5000, 300, 2097152, 7),
+	)
+
+	// Only the segments are missing without the privileges of pg_ls_waldir().
+	metrics := collectMetrics(c, newWALCollector(), server)
+
+	c.Assert(metrics, HasLen, 5)
+	c.Assert(metrics[0].name, Equals, "pg_wal_bytes_total")
+	c.Assert(metrics[1].name, Equals, "pg_stat_wal_records_total")
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
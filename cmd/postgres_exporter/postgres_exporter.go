@@ -1,31 +1,36 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/blang/semver"
 	"github.com/lib/pq"
-	"github.com/percona/exporter_shared"
 	"gopkg.in/yaml.v2"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/version"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -52,8 +57,29 @@ var (
 	disableDefaultMetrics         = kingpin.Flag("disable-default-metrics", "Do not include default metrics.").Default("false").Envar("PG_EXPORTER_DISABLE_DEFAULT_METRICS").Bool()
 	disableSettingsMetrics        = kingpin.Flag("disable-settings-metrics", "Do not include pg_settings metrics.").Default("false").Envar("PG_EXPORTER_DISABLE_SETTINGS_METRICS").Bool()
 	autoDiscoverDatabases         = kingpin.Flag("auto-discover-databases", "Whether to discover the databases on a server dynamically.").Default("false").Envar("PG_EXPORTER_AUTO_DISCOVER_DATABASES").Bool()
+	autoDiscoverMaxDatabases      = kingpin.Flag("auto-discover.max-databases", "Maximum number of databases discovered on each server, by name, 0 means no limit.").Default("0").Envar("PG_EXPORTER_AUTO_DISCOVER_MAX_DATABASES").Int()
+	dbDriver                      = kingpin.Flag("db.driver", "Database driver used to connect to PostgreSQL, one of: [pq, pgx].").Default(driverPQ).Envar("PG_EXPORTER_DB_DRIVER").Enum(driverPQ, driverPGX)
+	dbSSLMode                     = kingpin.Flag("db.sslmode", "SSL mode used to connect to PostgreSQL, unless set in the DSN.").Default("").Envar("PG_EXPORTER_DB_SSLMODE").String()
+	dbSSLCert                     = kingpin.Flag("db.sslcert", "Path to the client SSL certificate, unless set in the DSN.").Default("").Envar("PG_EXPORTER_DB_SSLCERT").String()
+	dbSSLKey                      = kingpin.Flag("db.sslkey", "Path to the client SSL key, unless set in the DSN.").Default("").Envar("PG_EXPORTER_DB_SSLKEY").String()
+	dbSSLRootCert                 = kingpin.Flag("db.sslrootcert", "Path to the SSL certificate authorities, unless set in the DSN.").Default("").Envar("PG_EXPORTER_DB_SSLROOTCERT").String()
+	dbMaxOpenConns                = kingpin.Flag("db.max-open-conns", "Maximum number of open connections to each database.").Default("1").Envar("PG_EXPORTER_DB_MAX_OPEN_CONNS").Int()
+	dbMaxIdleConns                = kingpin.Flag("db.max-idle-conns", "Maximum number of idle connections kept to each database, 0 closes connections after use.").Default("0").Envar("PG_EXPORTER_DB_MAX_IDLE_CONNS").Int()
+	dbMaxConcurrentQueries        = kingpin.Flag("db.max-concurrent-queries", "Maximum number of queries run at the same time on each host and port, across its databases and the concurrent scrapes, 0 means no limit.").Default("0").Envar("PG_EXPORTER_DB_MAX_CONCURRENT_QUERIES").Int()
+	dataSourceFile                = kingpin.Flag("config.data-source-file", "Path to a file with one DSN per line, scraped in addition to those of the environment. It is read again on /reload.").Default("").Envar("PG_EXPORTER_DATA_SOURCE_FILE").String()
+	dbInitSQL                     = kingpin.Flag("db.init-sql", "SQL run on each new connection to PostgreSQL, e.g. to set the role or session settings.").Default("").Envar("PG_EXPORTER_DB_INIT_SQL").String()
+	dbStatementTimeout            = kingpin.Flag("db.statement-timeout", "statement_timeout of the connections to PostgreSQL, 0 keeps the one of the server.").Default("0s").Envar("PG_EXPORTER_DB_STATEMENT_TIMEOUT").Duration()
+	dbLockTimeout                 = kingpin.Flag("db.lock-timeout", "lock_timeout of the connections to PostgreSQL, 0 keeps the one of the server.").Default("0s").Envar("PG_EXPORTER_DB_LOCK_TIMEOUT").Duration()
+	dbInstanceLabelsQuery         = kingpin.Flag("db.instance-labels-query", "SQL returning at most one row, whose columns are added as labels to all the metrics of the server, e.g. the name of the cluster.").Default("").Envar("PG_EXPORTER_DB_INSTANCE_LABELS_QUERY").String()
+	dbTLSServerName               = kingpin.Flag("db.tls-server-name", "TLS server name used instead of the host to connect to PostgreSQL, e.g. behind a proxy.").Default("").Envar("PG_EXPORTER_DB_TLS_SERVER_NAME").String()
+	maxParallelTargets            = kingpin.Flag("scrape.max-parallel-targets", "Maximum number of databases scraped at the same time across all requests, 0 means no limit.").Default("0").Envar("PG_EXPORTER_SCRAPE_MAX_PARALLEL_TARGETS").Int()
+	versionCacheTTL               = kingpin.Flag("scrape.version-cache-ttl", "How long the version of a /probe target is cached, 0 queries it on every probe.").Default("1h").Envar("PG_EXPORTER_SCRAPE_VERSION_CACHE_TTL").Duration()
+	maxConcurrency                = kingpin.Flag("scrape.max-concurrency", "Maximum number of databases scraped at the same time by a scrape, 0 means all of them.").Default("0").Envar("PG_EXPORTER_SCRAPE_MAX_CONCURRENCY").Int()
+	collectorRetries              = kingpin.Flag("collector.retries", "Number of times a collector is retried after a transient error, e.g. during a failover.").Default("0").Envar("PG_EXPORTER_COLLECTOR_RETRIES").Int()
+	collectorTimeout              = kingpin.Flag("collector.timeout", "Maximum duration of a single collector run, 0 disables the timeout.").Default("0s").Envar("PG_EXPORTER_COLLECTOR_TIMEOUT").Duration()
 	excludeDatabases              = kingpin.Flag("exclude-databases", "A list of databases to remove when autoDiscoverDatabases is enabled").Default("").Envar("PG_EXPORTER_EXCLUDE_DATABASES").String()
 	onlyDumpMaps                  = kingpin.Flag("dumpmaps", "Do not run, simply dump the maps.").Bool()
+	onlyCheckQueries              = kingpin.Flag("check-queries", "Do not run, check the custom query files of the enabled resolutions and exit.").Bool()
 	constantLabelsList            = kingpin.Flag("constantLabels", "A list of label=value separated by comma(,).").Default("").Envar("PG_EXPORTER_CONSTANT_LABELS").String()
 	collectCustomQueryLr          = kingpin.Flag("collect.custom_query.lr", "Enable custom queries with low resolution directory.").Default("false").Envar("PG_EXPORTER_EXTEND_QUERY_LR").Bool()
 	collectCustomQueryMr          = kingpin.Flag("collect.custom_query.mr", "Enable custom queries with medium resolution directory.").Default("false").Envar("PG_EXPORTER_EXTEND_QUERY_MR").Bool()
@@ -61,6 +87,11 @@ var (
 	collectCustomQueryLrDirectory = kingpin.Flag("collect.custom_query.lr.directory", "Path to custom queries with low resolution directory.").Envar("PG_EXPORTER_EXTEND_QUERY_LR_PATH").String()
 	collectCustomQueryMrDirectory = kingpin.Flag("collect.custom_query.mr.directory", "Path to custom queries with medium resolution directory.").Envar("PG_EXPORTER_EXTEND_QUERY_MR_PATH").String()
 	collectCustomQueryHrDirectory = kingpin.Flag("collect.custom_query.hr.directory", "Path to custom queries with high resolution directory.").Envar("PG_EXPORTER_EXTEND_QUERY_HR_PATH").String()
+	rejectUnsafeQueries           = kingpin.Flag("collect.custom_query.reject-unsafe", "Refuse to load the custom query files with a query which writes or changes the schema, e.g. DELETE or DROP.").Default("false").Envar("PG_EXPORTER_EXTEND_QUERY_REJECT_UNSAFE").Bool()
+	collectCustomQueryMaxRows     = kingpin.Flag("collect.custom_query.max-rows", "Maximum number of rows read from the result of a custom query, 0 means no limit.").Default("0").Envar("PG_EXPORTER_EXTEND_QUERY_MAX_ROWS").Uint64()
+	collectCustomQueryLrExclude   = kingpin.Flag("collect.custom_query.lr.exclude", "Glob pattern of custom query files to skip in the low resolution directory.").Envar("PG_EXPORTER_EXTEND_QUERY_LR_EXCLUDE").String()
+	collectCustomQueryMrExclude   = kingpin.Flag("collect.custom_query.mr.exclude", "Glob pattern of custom query files to skip in the medium resolution directory.").Envar("PG_EXPORTER_EXTEND_QUERY_MR_EXCLUDE").String()
+	collectCustomQueryHrExclude   = kingpin.Flag("collect.custom_query.hr.exclude", "Glob pattern of custom query files to skip in the high resolution directory.").Envar("PG_EXPORTER_EXTEND_QUERY_HR_EXCLUDE").String()
 )
 
 // Metric name parts.
@@ -76,6 +107,19 @@ const (
 	serverLabelName = "server"
 )
 
+// Database drivers which can be selected with the --db.driver flag.
+const (
+	driverPQ  = "pq"
+	driverPGX = "pgx"
+)
+
+// sqlDriverNames maps the --db.driver values to the names the drivers are
+// registered with in database/sql.
+var sqlDriverNames = map[string]string{
+	driverPQ:  "postgres",
+	driverPGX: "pgx",
+}
+
 type MetricResolution string
 
 const (
@@ -96,6 +140,7 @@ const (
 	GAUGE        ColumnUsage = iota // Use this column as a gauge
 	MAPPEDMETRIC ColumnUsage = iota // Use this column with the supplied mapping of text values
 	DURATION     ColumnUsage = iota // This column should be interpreted as a text duration (and converted to milliseconds)
+	HISTOGRAM    ColumnUsage = iota // Use the columns <name>_bucket, <name>_sum and <name>_count, one row per le, as a histogram
 )
 
 // UnmarshalYAML implements the yaml.Unmarshaller interface.
@@ -120,6 +165,8 @@ type MappingOptions struct {
 	Description       string             `yaml:"description"`
 	Mapping           map[string]float64 `yaml:"metric_mapping"` // Optional column mapping for MAPPEDMETRIC
 	SupportedVersions semver.Range       `yaml:"pg_version"`     // Semantic version ranges which are supported. Unsupported columns are not queried (internally converted to DISCARD).
+	Factor            float64            `yaml:"factor"`         // Optional factor the value is multiplied by, e.g. 0.001 to turn milliseconds into seconds.
+	Offset            float64            `yaml:"offset"`         // Optional offset added to the value, after the factor.
 }
 
 // nolint: golint
@@ -127,10 +174,34 @@ type Mapping map[string]MappingOptions
 
 // nolint: golint
 type UserQuery struct {
-	Query        string    `yaml:"query"`
-	Metrics      []Mapping `yaml:"metrics"`
-	Master       bool      `yaml:"master"`        // Querying only for master database
-	CacheSeconds uint64    `yaml:"cache_seconds"` // Number of seconds to cache the namespace result metrics for.
+	Query        string        `yaml:"query"`
+	Metrics      []Mapping     `yaml:"metrics"`
+	Master       bool          `yaml:"master"`        // Querying only for master database
+	CacheSeconds uint64        `yaml:"cache_seconds"` // Number of seconds to cache the namespace result metrics for.
+	Timeout      time.Duration `yaml:"timeout"`       // Maximum duration of the query. 0 disables.
+	MaxRows      uint64        `yaml:"max_rows"`      // Maximum number of rows read from the query result. 0 uses the global default.
+}
+
+// userQueryOptions holds the options of a user query which apply to running it.
+type userQueryOptions struct {
+	timeout    time.Duration
+	maxRows    uint64
+	transforms map[string]valueTransform // By column, for the columns with a factor or an offset.
+}
+
+// valueTransform turns the value of a column of a user query into
+// value * factor + offset.
+type valueTransform struct {
+	factor, offset float64
+}
+
+// apply returns the transformed value, or the value itself for a nil
+// transformation.
+func (t *valueTransform) apply(value float64) float64 {
+	if t == nil {
+		return value
+	}
+	return value*t.factor + t.offset
 }
 
 // nolint: golint
@@ -151,6 +222,23 @@ func parseVersion(versionString string) (semver.Version, error) {
 		errors.New(fmt.Sprintln("Could not find a postgres version in string:", versionString))
 }
 
+// Regex used to get the version from the server_version setting, e.g.
+// "13.2 (Debian 13.2-1.pgdg100+1)" or "16beta1".
+var settingVersionRegex = regexp.MustCompile(`^((\d+)(\.\d+)?(\.\d+)?)`)
+
+// parseVersionNum parses the server_version_num setting, e.g. 90624 for
+// 9.6.24 and 130002 for 13.2.
+func parseVersionNum(versionNum string) (semver.Version, error) {
+	num, err := strconv.ParseUint(strings.TrimSpace(versionNum), 10, 64)
+	if err != nil {
+		return semver.Version{}, err
+	}
+	if num >= 100000 {
+		return semver.Version{Major: num / 10000, Minor: num % 10000}, nil
+	}
+	return semver.Version{Major: num / 10000, Minor: num / 100 % 100, Patch: num % 100}, nil
+}
+
 // ColumnMapping is the user-friendly representation of a prometheus descriptor map
 type ColumnMapping struct {
 	usage             ColumnUsage        `yaml:"usage"`
@@ -179,6 +267,9 @@ type MetricMapNamespace struct {
 	columnMappings map[string]MetricMap // Column mappings in this namespace
 	master         bool                 // Call query only for master database
 	cacheSeconds   uint64               // Number of seconds this metric namespace can be cached. 0 disables.
+	timeout        time.Duration        // Maximum duration of the query. 0 disables.
+	maxRows        uint64               // Maximum number of rows read from the query result. 0 disables.
+	resolution     MetricResolution     // Resolution of a user query, empty for builtin ones.
 }
 
 // MetricMap stores the prometheus metric description which a given column will
@@ -188,6 +279,8 @@ type MetricMap struct {
 	vtype      prometheus.ValueType              // Prometheus valuetype
 	desc       *prometheus.Desc                  // Prometheus descriptor
 	conversion func(interface{}) (float64, bool) // Conversion function to turn PG result into float64
+	transform  *valueTransform                   // Transformation of the value of a user query column, nil for none
+	histogram  bool                              // Should metric be assembled from the rows of histogramColumns?
 }
 
 // ErrorConnectToServer is a connection to PgSQL server error
@@ -200,6 +293,29 @@ func (e *ErrorConnectToServer) Error() string {
 	return e.Msg
 }
 
+// ErrorRowLimitExceeded is reported when a query returns more rows than the
+// limit of its namespace. The rows past the limit are ignored.
+type ErrorRowLimitExceeded struct {
+	Namespace string
+	MaxRows   uint64
+}
+
+// Error returns error
+func (e *ErrorRowLimitExceeded) Error() string {
+	return fmt.Sprintf("query for %s returned more than %d rows, ignoring the rest", e.Namespace, e.MaxRows)
+}
+
+// ErrorQueryTimeout is returned when a query exceeds the timeout of its namespace
+type ErrorQueryTimeout struct {
+	Namespace string
+	Timeout   time.Duration
+}
+
+// Error returns error
+func (e *ErrorQueryTimeout) Error() string {
+	return fmt.Sprintf("query for %s timed out after %s", e.Namespace, e.Timeout)
+}
+
 // TODO: revisit this with the semver system
 func dumpMaps() {
 	// TODO: make this function part of the exporter
@@ -263,28 +379,6 @@ var builtinMetricMaps = map[string]intermediateMetricMap{
 		true,
 		0,
 	},
-	"pg_stat_database_conflicts": {
-		map[string]ColumnMapping{
-			"datid":            {LABEL, "OID of a database", nil, nil},
-			"datname":          {LABEL, "Name of this database", nil, nil},
-			"confl_tablespace": {COUNTER, "Number of queries in this database that have been canceled due to dropped tablespaces", nil, nil},
-			"confl_lock":       {COUNTER, "Number of queries in this database that have been canceled due to lock timeouts", nil, nil},
-			"confl_snapshot":   {COUNTER, "Number of queries in this database that have been canceled due to old snapshots", nil, nil},
-			"confl_bufferpin":  {COUNTER, "Number of queries in this database that have been canceled due to pinned buffers", nil, nil},
-			"confl_deadlock":   {COUNTER, "Number of queries in this database that have been canceled due to deadlocks", nil, nil},
-		},
-		true,
-		0,
-	},
-	"pg_locks": {
-		map[string]ColumnMapping{
-			"datname": {LABEL, "Name of this database", nil, nil},
-			"mode":    {LABEL, "Type of Lock", nil, nil},
-			"count":   {GAUGE, "Number of locks", nil, nil},
-		},
-		true,
-		0,
-	},
 	"pg_stat_replication": {
 		map[string]ColumnMapping{
 			"procpid":          {DISCARD, "Process ID of a WAL sender process", nil, semver.MustParseRange("<9.2.0")},
@@ -331,30 +425,6 @@ var builtinMetricMaps = map[string]intermediateMetricMap{
 		true,
 		0,
 	},
-	"pg_stat_archiver": {
-		map[string]ColumnMapping{
-			"archived_count":     {COUNTER, "Number of WAL files that have been successfully archived", nil, nil},
-			"last_archived_wal":  {DISCARD, "Name of the last WAL file successfully archived", nil, nil},
-			"last_archived_time": {DISCARD, "Time of the last successful archive operation", nil, nil},
-			"failed_count":       {COUNTER, "Number of failed attempts for archiving WAL files", nil, nil},
-			"last_failed_wal":    {DISCARD, "Name of the WAL file of the last failed archival operation", nil, nil},
-			"last_failed_time":   {DISCARD, "Time of the last failed archival operation", nil, nil},
-			"stats_reset":        {DISCARD, "Time at which these statistics were last reset", nil, nil},
-			"last_archive_age":   {GAUGE, "Time in seconds since last WAL segment was successfully archived", nil, nil},
-		},
-		true,
-		0,
-	},
-	"pg_stat_activity": {
-		map[string]ColumnMapping{
-			"datname":         {LABEL, "Name of this database", nil, nil},
-			"state":           {LABEL, "connection state", nil, semver.MustParseRange(">=9.2.0")},
-			"count":           {GAUGE, "number of connections in this state", nil, nil},
-			"max_tx_duration": {GAUGE, "max duration in seconds any active transaction has been running", nil, nil},
-		},
-		true,
-		0,
-	},
 }
 
 // OverrideQuery 's are run in-place of simple namespace look ups, and provide
@@ -369,30 +439,6 @@ type OverrideQuery struct {
 // Overriding queries for namespaces above.
 // TODO: validate this is a closed set in tests, and there are no overlaps
 var queryOverrides = map[string][]OverrideQuery{
-	"pg_locks": {
-		{
-			semver.MustParseRange(">0.0.0"),
-			`SELECT pg_database.datname,tmp.mode,COALESCE(count,0) as count
-			FROM
-				(
-				  VALUES ('accesssharelock'),
-				         ('rowsharelock'),
-				         ('rowexclusivelock'),
-				         ('shareupdateexclusivelock'),
-				         ('sharelock'),
-				         ('sharerowexclusivelock'),
-				         ('exclusivelock'),
-				         ('accessexclusivelock')
-				) AS tmp(mode) CROSS JOIN pg_database
-			LEFT JOIN
-			  (SELECT database, lower(mode) AS mode,count(*) AS count
-			  FROM pg_locks WHERE database IS NOT NULL
-			  GROUP BY database, lower(mode)
-			) AS tmp2
-			ON tmp.mode=tmp2.mode and pg_database.oid = tmp2.database ORDER BY 1`,
-		},
-	},
-
 	"pg_stat_replication": {
 		{
 			semver.MustParseRange(">=10.0.0"),
@@ -422,60 +468,6 @@ var queryOverrides = map[string][]OverrideQuery{
 			`,
 		},
 	},
-
-	"pg_stat_archiver": {
-		{
-			semver.MustParseRange(">=0.0.0"),
-			`
-			SELECT *,
-				extract(epoch from now() - last_archived_time) AS last_archive_age
-			FROM pg_stat_archiver
-			`,
-		},
-	},
-
-	"pg_stat_activity": {
-		// This query only works
-		{
-			semver.MustParseRange(">=9.2.0"),
-			`
-			SELECT
-				pg_database.datname,
-				tmp.state,
-				COALESCE(count,0) as count,
-				COALESCE(max_tx_duration,0) as max_tx_duration
-			FROM
-				(
-				  VALUES ('active'),
-				  		 ('idle'),
-				  		 ('idle in transaction'),
-				  		 ('idle in transaction (aborted)'),
-				  		 ('fastpath function call'),
-				  		 ('disabled')
-				) AS tmp(state) CROSS JOIN pg_database
-			LEFT JOIN
-			(
-				SELECT
-					datname,
-					state,
-					count(*) AS count,
-					MAX(EXTRACT(EPOCH FROM now() - xact_start))::float AS max_tx_duration
-				FROM pg_stat_activity GROUP BY datname,state) AS tmp2
-				ON tmp.state = tmp2.state AND pg_database.datname = tmp2.datname
-			`,
-		},
-		{
-			semver.MustParseRange("<9.2.0"),
-			`
-			SELECT
-				datname,
-				'unknown' AS state,
-				COALESCE(count(*),0) AS count,
-				COALESCE(MAX(EXTRACT(EPOCH FROM now() - xact_start))::float,0) AS max_tx_duration
-			FROM pg_stat_activity GROUP BY datname
-			`,
-		},
-	},
 }
 
 // Convert the query override file to the version-specific query override file
@@ -502,21 +494,28 @@ func makeQueryOverrideMap(pgVersion semver.Version, queryOverrides map[string][]
 	return resultMap
 }
 
-func parseUserQueries(content []byte) (map[string]intermediateMetricMap, map[string]string, error) {
+func parseUserQueries(content []byte) (map[string]intermediateMetricMap, map[string]string, map[string]userQueryOptions, error) {
 	var userQueries UserQueries
 
 	err := yaml.Unmarshal(content, &userQueries)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	// Stores the loaded map representation
 	metricMaps := make(map[string]intermediateMetricMap)
 	newQueryOverrides := make(map[string]string)
+	queryOptions := make(map[string]userQueryOptions)
 
 	for metric, specs := range userQueries {
 		log.Debugln("New user metric namespace from YAML:", metric, "Will cache results for:", specs.CacheSeconds)
 		newQueryOverrides[metric] = specs.Query
+		options := userQueryOptions{
+			timeout:    specs.Timeout,
+			maxRows:    specs.MaxRows,
+			transforms: make(map[string]valueTransform),
+		}
+		queryOptions[metric] = options
 		metricMap, ok := metricMaps[metric]
 		if !ok {
 			// Namespace for metric not found - add it.
@@ -535,6 +534,14 @@ func parseUserQueries(content []byte) (map[string]intermediateMetricMap, map[str
 				columnMapping.usage = tmpUsage
 				columnMapping.description = mappingOption.Description
 
+				if mappingOption.Factor != 0 || mappingOption.Offset != 0 {
+					factor := mappingOption.Factor
+					if factor == 0 {
+						factor = 1
+					}
+					options.transforms[name] = valueTransform{factor: factor, offset: mappingOption.Offset}
+				}
+
 				// TODO: we should support cu
 				columnMapping.mapping = nil
 				// Should we support this for users?
@@ -544,7 +551,7 @@ func parseUserQueries(content []byte) (map[string]intermediateMetricMap, map[str
 			}
 		}
 	}
-	return metricMaps, newQueryOverrides, nil
+	return metricMaps, newQueryOverrides, queryOptions, nil
 }
 
 // Add queries to the builtinMetricMaps and queryOverrides maps. Added queries do not
@@ -555,13 +562,30 @@ func parseUserQueries(content []byte) (map[string]intermediateMetricMap, map[str
 // queries.
 // TODO: test code for all cu.
 // TODO: the YAML this supports is "non-standard" - we should move away from it.
-func addQueries(content []byte, pgVersion semver.Version, server *Server) error {
-	metricMaps, newQueryOverrides, err := parseUserQueries(content)
+func addQueries(content []byte, pgVersion semver.Version, server *Server, resolution MetricResolution) error {
+	metricMaps, newQueryOverrides, queryOptions, err := parseUserQueries(content)
 	if err != nil {
-		return nil
+		return err
 	}
 	// Convert the loaded metric map into exporter representation
 	partialExporterMap := makeDescMap(pgVersion, server.labels, metricMaps)
+	for k, options := range queryOptions {
+		mapping := partialExporterMap[k]
+		mapping.timeout = options.timeout
+		mapping.maxRows = options.maxRows
+		if mapping.maxRows == 0 {
+			mapping.maxRows = server.userQueryMaxRows
+		}
+		mapping.resolution = resolution
+		for column, transform := range options.transforms {
+			if columnMapping, ok := mapping.columnMappings[column]; ok && !columnMapping.discard {
+				transform := transform
+				columnMapping.transform = &transform
+				mapping.columnMappings[column] = columnMapping
+			}
+		}
+		partialExporterMap[k] = mapping
+	}
 
 	// Merge the two maps (which are now quite flatteend)
 	for k, v := range partialExporterMap {
@@ -594,10 +618,10 @@ func makeDescMap(pgVersion semver.Version, serverLabels prometheus.Labels, metri
 	for namespace, intermediateMappings := range metricMaps {
 		thisMap := make(map[string]MetricMap)
 
-		// Get the constant labels
+		// Get the constant labels. The le label is set by the histograms.
 		var variableLabels []string
 		for columnName, columnMapping := range intermediateMappings.columnMappings {
-			if columnMapping.usage == LABEL {
+			if columnMapping.usage == LABEL && !(columnName == histogramBucketLabel && hasHistogram(intermediateMappings)) {
 				variableLabels = append(variableLabels, columnName)
 			}
 		}
@@ -663,6 +687,14 @@ func makeDescMap(pgVersion semver.Version, serverLabels prometheus.Labels, metri
 						return val, true
 					},
 				}
+			case HISTOGRAM:
+				thisMap[columnName] = MetricMap{
+					histogram: true,
+					desc:      prometheus.NewDesc(fmt.Sprintf("%s_%s", namespace, columnName), columnMapping.description, variableLabels, serverLabels),
+					conversion: func(_ interface{}) (float64, bool) {
+						return math.NaN(), false
+					},
+				}
 			case DURATION:
 				thisMap[columnName] = MetricMap{
 					vtype: prometheus.GaugeValue,
@@ -694,7 +726,24 @@ func makeDescMap(pgVersion semver.Version, serverLabels prometheus.Labels, metri
 			}
 		}
 
-		metricMap[namespace] = MetricMapNamespace{variableLabels, thisMap, intermediateMappings.master, intermediateMappings.cacheSeconds}
+		// The columns of the histograms aren't metrics on their own.
+		for columnName, columnMapping := range intermediateMappings.columnMappings {
+			if columnMapping.usage != HISTOGRAM {
+				continue
+			}
+			for _, column := range histogramColumns(columnName) {
+				if _, ok := intermediateMappings.columnMappings[column]; !ok || column == histogramBucketLabel {
+					thisMap[column] = MetricMap{
+						discard: true,
+						conversion: func(_ interface{}) (float64, bool) {
+							return math.NaN(), true
+						},
+					}
+				}
+			}
+		}
+
+		metricMap[namespace] = MetricMapNamespace{variableLabels, thisMap, intermediateMappings.master, intermediateMappings.cacheSeconds, 0, 0, ""}
 	}
 
 	return metricMap
@@ -723,6 +772,9 @@ func stringToColumnUsage(s string) (ColumnUsage, error) {
 	case "DURATION":
 		u = DURATION
 
+	case "HISTOGRAM":
+		u = HISTOGRAM
+
 	default:
 		err = fmt.Errorf("wrong ColumnUsage given : %s", s)
 	}
@@ -794,7 +846,8 @@ func dbToString(t interface{}) (string, bool) {
 	}
 }
 
-func parseFingerprint(url string) (string, error) {
+// parseDSN returns the key/value pairs of a URL or key/value DSN.
+func parseDSN(url string) (map[string]string, error) {
 	dsn, err := pq.ParseURL(url)
 	if err != nil {
 		dsn = url
@@ -805,26 +858,55 @@ func parseFingerprint(url string) (string, error) {
 	for _, pair := range pairs {
 		splitted := strings.SplitN(pair, "=", 2)
 		if len(splitted) != 2 {
-			return "", fmt.Errorf("malformed dsn %q", dsn)
+			return nil, fmt.Errorf("malformed dsn %q", dsn)
 		}
 		kv[splitted[0]] = splitted[1]
 	}
+	return kv, nil
+}
 
-	var fingerprint string
+// parseDatname returns the database of the DSN, which defaults to the user
+// like for libpq.
+func parseDatname(url string) string {
+	kv, err := parseDSN(url)
+	if err != nil {
+		return ""
+	}
+	if dbname, ok := kv["dbname"]; ok && dbname != "" {
+		return dbname
+	}
+	return kv["user"]
+}
 
-	if host, ok := kv["host"]; ok {
-		fingerprint += host
-	} else {
-		fingerprint += "localhost"
+func parseFingerprint(url string) (string, error) {
+	kv, err := parseDSN(url)
+	if err != nil {
+		return "", err
 	}
 
-	if port, ok := kv["port"]; ok {
-		fingerprint += ":" + port
-	} else {
-		fingerprint += ":5432"
+	host := "localhost"
+	if h, ok := kv["host"]; ok {
+		// IPv6 literals may or may not be bracketed, JoinHostPort adds them.
+		host = strings.TrimSuffix(strings.TrimPrefix(h, "["), "]")
+	}
+
+	port := "5432"
+	if p, ok := kv["port"]; ok {
+		port = p
+	}
+
+	// A host starting with a slash is the directory of a Unix socket, which
+	// only makes sense on the host of the exporter. The database is added
+	// to tell apart the servers reached through sockets.
+	if strings.HasPrefix(host, "/") {
+		fingerprint := host + ":" + port
+		if dbname, ok := kv["dbname"]; ok && dbname != "" {
+			fingerprint += "/" + dbname
+		}
+		return fingerprint, nil
 	}
 
-	return fingerprint, nil
+	return net.JoinHostPort(host, port), nil
 }
 
 func loggableDSN(dsn string) string {
@@ -845,12 +927,104 @@ type cachedMetrics struct {
 	lastScrape time.Time
 }
 
+// versionCache caches the version strings of the servers by fingerprint, so
+// that the short-lived connections of /probe don't query it on every probe.
+// The servers of /metrics don't use it: their connections are reused, so a
+// restart of the server wouldn't expire it. A nil cache, or one with a TTL
+// of 0, caches nothing.
+type versionCache struct {
+	ttl     time.Duration
+	mtx     sync.Mutex
+	entries map[string]cachedVersion
+	now     func() time.Time
+}
+
+type cachedVersion struct {
+	versionString string
+	expiry        time.Time
+}
+
+func newVersionCache(ttl time.Duration) *versionCache {
+	return &versionCache{
+		ttl:     ttl,
+		entries: make(map[string]cachedVersion),
+		now:     time.Now,
+	}
+}
+
+// get returns the cached version string of the server, if it didn't expire.
+func (c *versionCache) get(fingerprint string) (string, bool) {
+	if c == nil || c.ttl <= 0 {
+		return "", false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entry, ok := c.entries[fingerprint]
+	if !ok || !c.now().Before(entry.expiry) {
+		return "", false
+	}
+	return entry.versionString, true
+}
+
+// set caches the version string of the server.
+func (c *versionCache) set(fingerprint, versionString string) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.entries[fingerprint] = cachedVersion{versionString: versionString, expiry: c.now().Add(c.ttl)}
+}
+
+// invalidate makes the version of the server expire, e.g. because the
+// connection failed and the server may have been restarted with another
+// version.
+func (c *versionCache) invalidate(fingerprint string) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if entry, ok := c.entries[fingerprint]; ok {
+		entry.expiry = time.Time{}
+		c.entries[fingerprint] = entry
+	}
+}
+
 // Server describes a connection to Postgres.
 // Also it contains metrics map and query overrides.
 type Server struct {
 	db     *sql.DB
+	driver string
 	labels prometheus.Labels
 	master bool
+	// Database of the DSN
+	datname string
+	// Scraped by /probe, whose targets are left out of the internal metrics
+	probe bool
+
+	// TLS server name overriding the host of the DSN
+	tlsServerName string
+	// SQL run on each new connection
+	initSQL string
+	// statement_timeout and lock_timeout of the connections, 0 keeps the
+	// ones of the server
+	statementTimeout time.Duration
+	lockTimeout      time.Duration
+	// SQL returning the labels added to the metrics of the server
+	instanceLabelsSQL string
+	// Connection parameters added to the DSN unless it sets them
+	dsnParams map[string]string
+
+	// Connection pool limits
+	maxOpenConns int
+	maxIdleConns int
+	// Limits the queries run at the same time on the host and port, shared
+	// with the other servers
+	queryLimiter *queryLimiter
 
 	// Last version used to calculate metric map. If mismatch on scrape,
 	// then maps are recalculated.
@@ -863,6 +1037,18 @@ type Server struct {
 	// Currently cached metrics
 	metricCache map[string]cachedMetrics
 	cacheMtx    sync.Mutex
+	// Names of the collectors to run on scrape, and their instances
+	collectorNames  []string
+	collectors      []serverCollector
+	collectorConfig collectorConfig
+	versionCache    *versionCache
+	// Counts the user queries which exceeded their timeout
+	userQueryTimeouts *prometheus.CounterVec
+	// Default limit of rows read from user queries, and whether they exceeded it
+	userQueryMaxRows          uint64
+	userQueryRowLimitExceeded *prometheus.GaugeVec
+	// Duration of the user queries
+	userQueryDuration *prometheus.HistogramVec
 }
 
 // ServerOpt configures a server.
@@ -877,6 +1063,116 @@ func ServerWithLabels(labels prometheus.Labels) ServerOpt {
 	}
 }
 
+// ServerWithDriver configures the database driver, one of driverPQ or driverPGX.
+func ServerWithDriver(driver string) ServerOpt {
+	return func(s *Server) {
+		s.driver = driver
+	}
+}
+
+// ServerWithMaxConnections configures the connection pool limits.
+func ServerWithMaxConnections(maxOpen, maxIdle int) ServerOpt {
+	return func(s *Server) {
+		s.maxOpenConns = maxOpen
+		s.maxIdleConns = maxIdle
+	}
+}
+
+// ServerWithQueryLimiter configures the limiter of the queries run at the same
+// time on the host and port of the server.
+func ServerWithQueryLimiter(limiter *queryLimiter) ServerOpt {
+	return func(s *Server) {
+		s.queryLimiter = limiter
+	}
+}
+
+// ServerWithCollectors configures the collectors to run on scrape.
+func ServerWithCollectors(names []string) ServerOpt {
+	return func(s *Server) {
+		s.collectorNames = names
+	}
+}
+
+// ServerWithVersionCache configures the cache of the server version.
+func ServerWithVersionCache(cache *versionCache) ServerOpt {
+	return func(s *Server) {
+		s.versionCache = cache
+	}
+}
+
+// ServerWithCollectorConfig configures the options of the collectors.
+func ServerWithCollectorConfig(config collectorConfig) ServerOpt {
+	return func(s *Server) {
+		s.collectorConfig = config
+	}
+}
+
+// ServerWithUserQueryTimeouts configures the counter of user queries which
+// exceeded their timeout.
+func ServerWithUserQueryTimeouts(counter *prometheus.CounterVec) ServerOpt {
+	return func(s *Server) {
+		s.userQueryTimeouts = counter
+	}
+}
+
+// ServerWithTLSServerName configures the TLS server name used instead of the
+// host of the DSN.
+func ServerWithTLSServerName(name string) ServerOpt {
+	return func(s *Server) {
+		s.tlsServerName = name
+	}
+}
+
+// ServerWithInitSQL configures the SQL run on each new connection.
+func ServerWithInitSQL(sql string) ServerOpt {
+	return func(s *Server) {
+		s.initSQL = sql
+	}
+}
+
+// ServerWithSessionTimeouts configures the statement_timeout and lock_timeout
+// of the connections, 0 keeps the ones of the server.
+func ServerWithSessionTimeouts(statementTimeout, lockTimeout time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.statementTimeout = statementTimeout
+		s.lockTimeout = lockTimeout
+	}
+}
+
+// ServerWithInstanceLabelsQuery configures the query returning the labels
+// added to the metrics of the server.
+func ServerWithInstanceLabelsQuery(query string) ServerOpt {
+	return func(s *Server) {
+		s.instanceLabelsSQL = query
+	}
+}
+
+// ServerWithDSNParams configures connection parameters, e.g. sslmode, added
+// to the DSN unless it already sets them.
+func ServerWithDSNParams(params map[string]string) ServerOpt {
+	return func(s *Server) {
+		s.dsnParams = params
+	}
+}
+
+// ServerWithUserQueryDuration configures the histogram of the duration of the
+// user queries.
+func ServerWithUserQueryDuration(histogram *prometheus.HistogramVec) ServerOpt {
+	return func(s *Server) {
+		s.userQueryDuration = histogram
+	}
+}
+
+// ServerWithUserQueryRowLimit configures the default maximum number of rows
+// read from user queries, and the gauge reporting the queries which
+// exceeded their limit.
+func ServerWithUserQueryRowLimit(maxRows uint64, exceeded *prometheus.GaugeVec) ServerOpt {
+	return func(s *Server) {
+		s.userQueryMaxRows = maxRows
+		s.userQueryRowLimitExceeded = exceeded
+	}
+}
+
 // NewServer establishes a new connection using DSN.
 func NewServer(dsn string, opts ...ServerOpt) (*Server, error) {
 	fingerprint, err := parseFingerprint(dsn)
@@ -884,21 +1180,14 @@ func NewServer(dsn string, opts ...ServerOpt) (*Server, error) {
 		return nil, err
 	}
 
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		return nil, err
-	}
-	db.SetMaxOpenConns(1)
-	db.SetMaxIdleConns(-1)
-
-	log.Infof("Established new database connection to %q.", fingerprint)
-
 	s := &Server{
-		db:     db,
-		master: false,
+		driver:       driverPQ,
+		master:       false,
+		maxOpenConns: 1,
 		labels: prometheus.Labels{
 			serverLabelName: fingerprint,
 		},
+		datname:     parseDatname(dsn),
 		metricCache: make(map[string]cachedMetrics),
 	}
 
@@ -906,9 +1195,89 @@ func NewServer(dsn string, opts ...ServerOpt) (*Server, error) {
 		opt(s)
 	}
 
+	if _, ok := sqlDriverNames[s.driver]; !ok {
+		return nil, fmt.Errorf("unknown database driver %q", s.driver)
+	}
+
+	if s.collectors, err = newServerCollectors(s.collectorNames); err != nil {
+		return nil, err
+	}
+
+	if dsn, err = withDefaultParams(dsn, s.dsnParams); err != nil {
+		return nil, err
+	}
+
+	initSQL := s.initSQL
+	if timeouts := sessionTimeoutsSQL(s.statementTimeout, s.lockTimeout); timeouts != "" {
+		// The init SQL runs afterwards, so that it may override them.
+		initSQL = strings.TrimSuffix(timeouts+"; "+initSQL, "; ")
+	}
+	db, err := openDB(s.driver, dsn, s.tlsServerName, initSQL)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(s.maxOpenConns)
+	db.SetMaxIdleConns(s.maxIdleConns)
+	s.db = db
+
+	if s.instanceLabelsSQL != "" {
+		if err := s.loadInstanceLabels(); err != nil {
+			s.Close() // nolint: errcheck
+			return nil, fmt.Errorf("error querying the instance labels: %w", err)
+		}
+	}
+
+	log.Infof("Established new database connection to %q using %s driver.", fingerprint, s.driver)
+
 	return s, nil
 }
 
+// loadInstanceLabels adds the columns of the row returned by the instance
+// labels query to the labels of the server. No row adds no label.
+func (s *Server) loadInstanceLabels() error {
+	rows, err := s.db.Query(s.instanceLabelsSQL)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if !rows.Next() {
+		return rows.Err()
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+	if rows.Next() {
+		return errors.New("the query returned more than one row")
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		if !model.LabelName(column).IsValid() {
+			return fmt.Errorf("invalid label name %q", column)
+		}
+		if _, ok := s.labels[column]; ok {
+			return fmt.Errorf("label %q is already set", column)
+		}
+	}
+	for i, column := range columns {
+		s.labels[column] = values[i].String
+	}
+	return nil
+}
+
 // Close disconnects from Postgres.
 func (s *Server) Close() error {
 	return s.db.Close()
@@ -917,6 +1286,7 @@ func (s *Server) Close() error {
 // Ping checks connection availability and possibly invalidates the connection if it fails.
 func (s *Server) Ping() error {
 	if err := s.db.Ping(); err != nil {
+		s.versionCache.invalidate(s.String())
 		if cerr := s.Close(); cerr != nil {
 			log.Errorf("Error while closing non-pinging DB connection to %q: %v", s, cerr)
 		}
@@ -938,9 +1308,11 @@ func (s *Server) Scrape(ch chan<- prometheus.Metric, disableSettingsMetrics bool
 	var err error
 
 	if !disableSettingsMetrics && s.master {
+		s.queryLimiter.acquire(s.String())
 		if err = querySettings(ch, s); err != nil {
 			err = fmt.Errorf("error retrieving settings: %s", err)
 		}
+		s.queryLimiter.release(s.String())
 	}
 
 	errMap := queryNamespaceMappings(ch, s)
@@ -955,23 +1327,35 @@ func (s *Server) Scrape(ch chan<- prometheus.Metric, disableSettingsMetrics bool
 type Servers struct {
 	m       sync.Mutex
 	servers map[string]*Server
-	opts    []ServerOpt
+	// Serialize the connections to each DSN, without blocking the others.
+	dsnLocks map[string]*sync.Mutex
+	opts     []ServerOpt
 }
 
 // NewServers creates a collection of servers to Postgres.
 func NewServers(opts ...ServerOpt) *Servers {
 	return &Servers{
-		servers: make(map[string]*Server),
-		opts:    opts,
+		servers:  make(map[string]*Server),
+		dsnLocks: make(map[string]*sync.Mutex),
+		opts:     opts,
 	}
 }
 
-// GetServer returns established connection from a collection.
+// GetServer returns established connection from a collection. Connecting,
+// and waiting between the retries, only blocks the callers asking for the
+// same DSN.
 func (s *Servers) GetServer(dsn string) (*Server, error) {
 	s.m.Lock()
-	defer s.m.Unlock()
+	dsnLock, ok := s.dsnLocks[dsn]
+	if !ok {
+		dsnLock = &sync.Mutex{}
+		s.dsnLocks[dsn] = dsnLock
+	}
+	s.m.Unlock()
+
+	dsnLock.Lock()
+	defer dsnLock.Unlock()
 	var err error
-	var ok bool
 	errCount := 0 // start at zero because we increment before doing work
 	retries := 3
 	var server *Server
@@ -979,17 +1363,23 @@ func (s *Servers) GetServer(dsn string) (*Server, error) {
 		if errCount++; errCount > retries {
 			return nil, err
 		}
+		s.m.Lock()
 		server, ok = s.servers[dsn]
+		s.m.Unlock()
 		if !ok {
 			server, err = NewServer(dsn, s.opts...)
 			if err != nil {
 				time.Sleep(time.Duration(errCount) * time.Second)
 				continue
 			}
+			s.m.Lock()
 			s.servers[dsn] = server
+			s.m.Unlock()
 		}
 		if err = server.Ping(); err != nil {
+			s.m.Lock()
 			delete(s.servers, dsn)
+			s.m.Unlock()
 			time.Sleep(time.Duration(errCount) * time.Second)
 			continue
 		}
@@ -998,35 +1388,111 @@ func (s *Servers) GetServer(dsn string) (*Server, error) {
 	return server, nil
 }
 
-// Close disconnects from all known servers.
-func (s *Servers) Close() {
+// Retain closes the connections of the servers whose DSN isn't kept, and
+// forgets them.
+func (s *Servers) Retain(keep func(dsn string) bool) {
 	s.m.Lock()
 	defer s.m.Unlock()
-	for _, server := range s.servers {
+	for dsn, server := range s.servers {
+		if keep(dsn) {
+			continue
+		}
 		if err := server.Close(); err != nil {
 			log.Errorf("failed to close connection to %q: %v", server, err)
 		}
+		delete(s.servers, dsn)
+		delete(s.dsnLocks, dsn)
 	}
 }
 
-// Exporter collects Postgres metrics. It implements prometheus.Collector.
-type Exporter struct {
-	// Holds a reference to the build in column mappings. Currently this is for testing purposes
-	// only, since it just points to the global.
-	builtinMetricMaps map[string]intermediateMetricMap
-
-	disableDefaultMetrics, disableSettingsMetrics, autoDiscoverDatabases bool
-
-	excludeDatabases   []string
+// InvalidateMaps makes all known servers recalculate their metric maps, and
+// reload the custom queries, on their next scrape.
+func (s *Servers) InvalidateMaps() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, server := range s.servers {
+		server.mappingMtx.Lock()
+		server.metricMap = nil
+		server.mappingMtx.Unlock()
+
+		server.cacheMtx.Lock()
+		server.metricCache = make(map[string]cachedMetrics)
+		server.cacheMtx.Unlock()
+	}
+}
+
+// Close disconnects from all known servers.
+func (s *Servers) Close() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, server := range s.servers {
+		if err := server.Close(); err != nil {
+			log.Errorf("failed to close connection to %q: %v", server, err)
+		}
+	}
+}
+
+// Exporter collects Postgres metrics. It implements prometheus.Collector.
+type Exporter struct {
+	// Holds a reference to the build in column mappings. Currently this is for testing purposes
+	// only, since it just points to the global.
+	builtinMetricMaps map[string]intermediateMetricMap
+
+	disableDefaultMetrics, disableSettingsMetrics, autoDiscoverDatabases bool
+
+	excludeDatabases   []string
+	maxDatabases       int
 	dsn                []string
+	dataSourceFile     string
+	dsnMtx             sync.RWMutex // Protects fileDSN, replaced on reload
+	fileDSN            []string
+	driver             string
+	tlsServerName      string
+	initSQL            string
+	statementTimeout   time.Duration
+	lockTimeout        time.Duration
+	instanceLabelsSQL  string
+	dsnParams          map[string]string
+	maxOpenConns       int
+	maxIdleConns       int
+	maxTargetQueries   int
+	queryLimiter       *queryLimiter
+	collectors         []string
+	collectorTimeout   time.Duration
+	collectorRetries   int
+	collectorRetryWait time.Duration
+	deniedBackoff      time.Duration
+	maxParallelTargets int
+	targetSlots        chan struct{}
+	maxConcurrency     int
+	versionCacheTTL    time.Duration
+	versionCache       *versionCache
+	probeCollectors    *probeCollectorCache
+	serverVersions     map[string]semver.Version
+	serverVersionsMtx  sync.Mutex
 	userQueriesPath    map[MetricResolution]string
+	userQueriesExclude map[MetricResolution]string
 	userQueriesEnabled map[MetricResolution]bool
+	rejectUnsafeQuery  bool
 	constantLabels     prometheus.Labels
 	duration           prometheus.Gauge
 	error              prometheus.Gauge
 	psqlUp             prometheus.Gauge
+	databasesTruncated prometheus.Gauge
 	userQueriesError   *prometheus.GaugeVec
 	totalScrapes       prometheus.Counter
+	collectorTimeouts  *prometheus.CounterVec
+	collectorDenied    *prometheus.GaugeVec
+	collectorEnabled   *prometheus.GaugeVec
+	collectorDuration  *prometheus.GaugeVec
+	collectorSuccess   *prometheus.GaugeVec
+	versionChanges     *prometheus.CounterVec
+	collectorErrors    *prometheus.CounterVec
+	userQueryTimeouts  *prometheus.CounterVec
+	userQueryMaxRows   uint64
+	userQueryRowLimit  *prometheus.GaugeVec
+	userQueryDuration  *prometheus.HistogramVec
+	databaseUp         *prometheus.Desc
 
 	// servers are used to allow re-using the DB connection between scrapes.
 	// servers contains metrics map and query overrides.
@@ -1064,6 +1530,155 @@ func ExcludeDatabases(s string) ExporterOpt {
 	}
 }
 
+// WithMaxDatabases caps the number of databases discovered on each server by
+// AutoDiscoverDatabases, 0 means no limit.
+func WithMaxDatabases(n int) ExporterOpt {
+	return func(e *Exporter) {
+		e.maxDatabases = n
+	}
+}
+
+// WithDriver configures the database driver used to connect to the servers.
+func WithDriver(driver string) ExporterOpt {
+	return func(e *Exporter) {
+		e.driver = driver
+	}
+}
+
+// WithMaxConnections configures the connection pool limits for every server.
+func WithMaxConnections(maxOpen, maxIdle int) ExporterOpt {
+	return func(e *Exporter) {
+		e.maxOpenConns = maxOpen
+		e.maxIdleConns = maxIdle
+	}
+}
+
+// WithCollectors configures the collectors to run in addition to the
+// namespace mappings.
+func WithCollectors(names []string) ExporterOpt {
+	return func(e *Exporter) {
+		e.collectors = names
+	}
+}
+
+// WithCollectorTimeout configures the maximum duration of a single collector run.
+func WithCollectorTimeout(d time.Duration) ExporterOpt {
+	return func(e *Exporter) {
+		e.collectorTimeout = d
+	}
+}
+
+// WithCollectorRetries configures the number of times a collector is retried
+// after a transient error.
+func WithCollectorRetries(n int) ExporterOpt {
+	return func(e *Exporter) {
+		e.collectorRetries = n
+	}
+}
+
+// WithDataSourceFile configures a file with DSNs scraped in addition to those
+// given to NewExporter. It is read by reloadDataSourceFile.
+func WithDataSourceFile(path string) ExporterOpt {
+	return func(e *Exporter) {
+		e.dataSourceFile = path
+	}
+}
+
+// WithDSNParams configures connection parameters added to the DSNs unless
+// they already set them.
+func WithDSNParams(params map[string]string) ExporterOpt {
+	return func(e *Exporter) {
+		e.dsnParams = params
+	}
+}
+
+// WithTLSServerName configures the TLS server name used instead of the host
+// of the DSNs.
+func WithTLSServerName(name string) ExporterOpt {
+	return func(e *Exporter) {
+		e.tlsServerName = name
+	}
+}
+
+// WithInitSQL configures the SQL run on each new connection.
+func WithInitSQL(sql string) ExporterOpt {
+	return func(e *Exporter) {
+		e.initSQL = sql
+	}
+}
+
+// WithSessionTimeouts configures the statement_timeout and lock_timeout of
+// the connections, 0 keeps the ones of the server.
+func WithSessionTimeouts(statementTimeout, lockTimeout time.Duration) ExporterOpt {
+	return func(e *Exporter) {
+		e.statementTimeout = statementTimeout
+		e.lockTimeout = lockTimeout
+	}
+}
+
+// WithInstanceLabelsQuery configures the query returning the labels added to
+// the metrics of each server.
+func WithInstanceLabelsQuery(query string) ExporterOpt {
+	return func(e *Exporter) {
+		e.instanceLabelsSQL = query
+	}
+}
+
+// WithVersionCacheTTL configures how long the version of a /probe target is
+// cached.
+func WithVersionCacheTTL(ttl time.Duration) ExporterOpt {
+	return func(e *Exporter) {
+		e.versionCacheTTL = ttl
+	}
+}
+
+// WithMaxTargetQueries limits the number of queries run at the same time on
+// each host and port, across its databases and the concurrent scrapes.
+func WithMaxTargetQueries(n int) ExporterOpt {
+	return func(e *Exporter) {
+		e.maxTargetQueries = n
+	}
+}
+
+// WithMaxConcurrency limits the number of databases scraped at the same time
+// by a single scrape.
+func WithMaxConcurrency(n int) ExporterOpt {
+	return func(e *Exporter) {
+		e.maxConcurrency = n
+	}
+}
+
+// WithMaxParallelTargets limits the number of databases scraped at the same
+// time, across concurrent scrapes.
+func WithMaxParallelTargets(n int) ExporterOpt {
+	return func(e *Exporter) {
+		e.maxParallelTargets = n
+	}
+}
+
+// WithUserQueriesExclude configures glob patterns of user's queries files to skip.
+func WithUserQueriesExclude(p map[MetricResolution]string) ExporterOpt {
+	return func(e *Exporter) {
+		e.userQueriesExclude = p
+	}
+}
+
+// WithUserQueriesMaxRows configures the default maximum number of rows read
+// from user queries.
+func WithUserQueriesMaxRows(n uint64) ExporterOpt {
+	return func(e *Exporter) {
+		e.userQueryMaxRows = n
+	}
+}
+
+// WithRejectUnsafeQueries configures whether the user's queries files with a
+// query which writes or changes the schema are rejected.
+func WithRejectUnsafeQueries(reject bool) ExporterOpt {
+	return func(e *Exporter) {
+		e.rejectUnsafeQuery = reject
+	}
+}
+
 // WithUserQueriesPath configures user's queries path.
 func WithUserQueriesPath(p map[MetricResolution]string) ExporterOpt {
 	return func(e *Exporter) {
@@ -1114,14 +1729,26 @@ func parseConstLabels(s string) prometheus.Labels {
 // NewExporter returns a new PostgreSQL exporter for the provided DSN.
 func NewExporter(dsn []string, opts ...ExporterOpt) *Exporter {
 	e := &Exporter{
-		dsn:               dsn,
-		builtinMetricMaps: builtinMetricMaps,
+		dsn:                dsn,
+		driver:             driverPQ,
+		maxOpenConns:       1,
+		collectorRetryWait: defaultCollectorRetryWait,
+		deniedBackoff:      defaultDeniedBackoff,
+		builtinMetricMaps:  builtinMetricMaps,
+		serverVersions:     make(map[string]semver.Version),
 	}
 
 	for _, opt := range opts {
 		opt(e)
 	}
 
+	if e.maxParallelTargets > 0 {
+		e.targetSlots = make(chan struct{}, e.maxParallelTargets)
+	}
+	e.versionCache = newVersionCache(e.versionCacheTTL)
+	e.probeCollectors = newProbeCollectorCache(defaultProbeCollectorTTL)
+	e.queryLimiter = newQueryLimiter(e.maxTargetQueries)
+
 	e.setupInternalMetrics()
 	e.setupServers()
 
@@ -1129,7 +1756,27 @@ func NewExporter(dsn []string, opts ...ExporterOpt) *Exporter {
 }
 
 func (e *Exporter) setupServers() {
-	e.servers = NewServers(ServerWithLabels(e.constantLabels))
+	e.servers = NewServers(e.serverOpts()...)
+}
+
+// serverOpts returns the options of the servers of the exporter.
+func (e *Exporter) serverOpts() []ServerOpt {
+	return []ServerOpt{
+		ServerWithLabels(e.constantLabels),
+		ServerWithDriver(e.driver),
+		ServerWithTLSServerName(e.tlsServerName),
+		ServerWithInitSQL(e.initSQL),
+		ServerWithSessionTimeouts(e.statementTimeout, e.lockTimeout),
+		ServerWithInstanceLabelsQuery(e.instanceLabelsSQL),
+		ServerWithDSNParams(e.dsnParams),
+		ServerWithMaxConnections(e.maxOpenConns, e.maxIdleConns),
+		ServerWithQueryLimiter(e.queryLimiter),
+		ServerWithCollectors(e.collectors),
+		ServerWithCollectorConfig(newCollectorConfig(e.excludeDatabases)),
+		ServerWithUserQueryTimeouts(e.userQueryTimeouts),
+		ServerWithUserQueryRowLimit(e.userQueryMaxRows, e.userQueryRowLimit),
+		ServerWithUserQueryDuration(e.userQueryDuration),
+	}
 }
 
 func (e *Exporter) setupInternalMetrics() {
@@ -1160,6 +1807,13 @@ func (e *Exporter) setupInternalMetrics() {
 		Help:        "Whether the last scrape of metrics from PostgreSQL was able to connect to the server (1 for yes, 0 for no).",
 		ConstLabels: e.constantLabels,
 	})
+	e.databasesTruncated = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   exporter,
+		Name:        "databases_truncated",
+		Help:        "Whether the discovered databases were truncated to --auto-discover.max-databases (1 for yes, 0 for no).",
+		ConstLabels: e.constantLabels,
+	})
 	e.userQueriesError = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace:   namespace,
 		Subsystem:   exporter,
@@ -1167,6 +1821,86 @@ func (e *Exporter) setupInternalMetrics() {
 		Help:        "Whether the user queries file was loaded and parsed successfully (1 for error, 0 for success).",
 		ConstLabels: e.constantLabels,
 	}, []string{"filename", "hashsum"})
+	e.collectorDenied = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   exporter,
+		Name:        "collector_permission_denied",
+		Help:        "Whether a collector is disabled on a server because the user lacks the privileges it needs (1 for yes, 0 for no).",
+		ConstLabels: e.constantLabels,
+	}, []string{"collector", serverLabelName})
+	e.collectorEnabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   exporter,
+		Name:        "collector_enabled",
+		Help:        "Whether a collector is enabled (1 for yes, 0 for no).",
+		ConstLabels: e.constantLabels,
+	}, []string{"collector"})
+	for name := range collectorSpecs {
+		e.collectorEnabled.WithLabelValues(name).Set(0)
+	}
+	for _, name := range e.collectors {
+		e.collectorEnabled.WithLabelValues(name).Set(1)
+	}
+	e.collectorDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   exporter,
+		Name:        "collector_duration_seconds",
+		Help:        "Duration of the last run of a collector on a server, including its retries, in seconds.",
+		ConstLabels: e.constantLabels,
+	}, []string{"collector", serverLabelName})
+	e.collectorSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   exporter,
+		Name:        "collector_success",
+		Help:        "Whether the last run of a collector on a server succeeded (1 for yes, 0 for no).",
+		ConstLabels: e.constantLabels,
+	}, []string{"collector", serverLabelName})
+	e.versionChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   exporter,
+		Name:        "version_changed_total",
+		Help:        "Number of times the version of the server changed since the previous scrape, e.g. after an upgrade.",
+		ConstLabels: e.constantLabels,
+	}, []string{serverLabelName})
+	e.collectorErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Name:        "collector_errors_total",
+		Help:        "Total number of failed collector runs on a server, including the retried ones, by error class.",
+		ConstLabels: e.constantLabels,
+	}, []string{"collector", serverLabelName, "class"})
+	e.collectorTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Name:        "collector_timeout_total",
+		Help:        "Total number of times a collector run on a server exceeded the collector timeout.",
+		ConstLabels: e.constantLabels,
+	}, []string{"collector", serverLabelName})
+	e.databaseUp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "database", "up"),
+		"Whether the last scrape of the auto-discovered database succeeded (1 for yes, 0 for no).",
+		[]string{"datname", serverLabelName}, e.constantLabels,
+	)
+	e.userQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   namespace,
+		Subsystem:   exporter,
+		Name:        "user_query_duration_seconds",
+		Help:        "Duration of the user queries, by query name and resolution.",
+		ConstLabels: e.constantLabels,
+		Buckets:     []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"query", "resolution"})
+	e.userQueryRowLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   exporter,
+		Name:        "user_query_row_limit_exceeded",
+		Help:        "Whether the user query returned more rows than its limit in the last run (1 for yes, 0 for no).",
+		ConstLabels: e.constantLabels,
+	}, []string{"query_name"})
+	e.userQueryTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   exporter,
+		Name:        "user_query_timeout_total",
+		Help:        "Total number of times a user query exceeded its timeout.",
+		ConstLabels: e.constantLabels,
+	}, []string{"query"})
 }
 
 // Describe implements prometheus.Collector.
@@ -1204,7 +1938,18 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	ch <- e.totalScrapes
 	ch <- e.error
 	ch <- e.psqlUp
+	ch <- e.databasesTruncated
 	e.userQueriesError.Collect(ch)
+	e.collectorTimeouts.Collect(ch)
+	e.collectorDenied.Collect(ch)
+	e.collectorEnabled.Collect(ch)
+	e.collectorDuration.Collect(ch)
+	e.collectorSuccess.Collect(ch)
+	e.versionChanges.Collect(ch)
+	e.collectorErrors.Collect(ch)
+	e.userQueryTimeouts.Collect(ch)
+	e.userQueryRowLimit.Collect(ch)
+	e.userQueryDuration.Collect(ch)
 }
 
 func newDesc(subsystem, name, help string, labels prometheus.Labels) *prometheus.Desc {
@@ -1242,6 +1987,115 @@ func queryDatabases(server *Server) ([]string, error) {
 	return result, nil
 }
 
+// histogramBucketLabel is the column of the upper bound of the buckets of the
+// HISTOGRAM columns.
+const histogramBucketLabel = "le"
+
+// histogramColumns returns the columns a HISTOGRAM column is assembled from:
+// the cumulative count of the bucket, the sum and the count of the
+// observations, and the upper bound of the bucket.
+func histogramColumns(name string) []string {
+	return []string{name + "_bucket", name + "_sum", name + "_count", histogramBucketLabel}
+}
+
+// hasHistogram reports whether a namespace has a HISTOGRAM column.
+func hasHistogram(mappings intermediateMetricMap) bool {
+	for _, columnMapping := range mappings.columnMappings {
+		if columnMapping.usage == HISTOGRAM {
+			return true
+		}
+	}
+	return false
+}
+
+// histogramRows assembles the rows of a HISTOGRAM column, one per bucket,
+// into a histogram per label values.
+type histogramRows struct {
+	desc                               *prometheus.Desc
+	bucketIdx, sumIdx, countIdx, leIdx int
+	keys                               []string // In the order they were first seen.
+	histograms                         map[string]*constHistogram
+}
+
+// constHistogram holds the values of a histogram read from the rows.
+type constHistogram struct {
+	labels  []string
+	buckets map[float64]uint64
+	sum     float64
+	count   uint64
+}
+
+// newHistogramRows returns the histogramRows of a HISTOGRAM column, or an
+// error naming the columns missing from the result of the query.
+func newHistogramRows(namespace, columnName string, metricMapping MetricMap, columnIdx map[string]int) (*histogramRows, error) {
+	var missing []string
+	idx := make([]int, 0, 4)
+	for _, column := range histogramColumns(columnName) {
+		i, ok := columnIdx[column]
+		if !ok {
+			missing = append(missing, column)
+		}
+		idx = append(idx, i)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("HISTOGRAM column %s of %s requires the columns %s, missing %s",
+			columnName, namespace, strings.Join(histogramColumns(columnName), ", "), strings.Join(missing, ", "))
+	}
+	return &histogramRows{
+		desc:       metricMapping.desc,
+		bucketIdx:  idx[0],
+		sumIdx:     idx[1],
+		countIdx:   idx[2],
+		leIdx:      idx[3],
+		histograms: make(map[string]*constHistogram),
+	}, nil
+}
+
+// add adds the bucket of a row to the histogram of its label values. The +Inf
+// bucket is the count, which the histogram has already.
+func (h *histogramRows) add(columnData []interface{}, labels []string) error {
+	le, ok := dbToFloat64(columnData[h.leIdx])
+	if !ok || math.IsNaN(le) {
+		return fmt.Errorf("invalid %s %v", histogramBucketLabel, columnData[h.leIdx])
+	}
+	bucket, ok := dbToFloat64(columnData[h.bucketIdx])
+	if !ok || math.IsNaN(bucket) {
+		return fmt.Errorf("invalid bucket count %v", columnData[h.bucketIdx])
+	}
+	sum, ok := dbToFloat64(columnData[h.sumIdx])
+	if !ok {
+		return fmt.Errorf("invalid sum %v", columnData[h.sumIdx])
+	}
+	count, ok := dbToFloat64(columnData[h.countIdx])
+	if !ok || math.IsNaN(count) {
+		return fmt.Errorf("invalid count %v", columnData[h.countIdx])
+	}
+
+	key := strings.Join(labels, "\xff")
+	histogram, ok := h.histograms[key]
+	if !ok {
+		histogram = &constHistogram{labels: labels, buckets: make(map[float64]uint64)}
+		h.histograms[key] = histogram
+		h.keys = append(h.keys, key)
+	}
+	if !math.IsInf(le, 1) {
+		histogram.buckets[le] = uint64(bucket)
+	}
+	histogram.sum = sum
+	histogram.count = uint64(count)
+	return nil
+}
+
+// metrics returns the histograms.
+func (h *histogramRows) metrics() []prometheus.Metric {
+	metrics := make([]prometheus.Metric, 0, len(h.keys))
+	for _, key := range h.keys {
+		histogram := h.histograms[key]
+		metrics = append(metrics, prometheus.MustNewConstHistogram(h.desc, histogram.count, histogram.sum, histogram.buckets, histogram.labels...))
+	}
+	return metrics
+}
+
 // Query within a namespace mapping and emit metrics. Returns fatal errors if
 // the scrape fails, and a slice of errors if they were non-fatal.
 func queryNamespaceMapping(server *Server, namespace string, mapping MetricMapNamespace) ([]prometheus.Metric, []error, error) {
@@ -1259,14 +2113,24 @@ func queryNamespaceMapping(server *Server, namespace string, mapping MetricMapNa
 	var rows *sql.Rows
 	var err error
 
+	ctx := context.Background()
+	if mapping.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mapping.timeout)
+		defer cancel()
+	}
+
 	if !found {
 		// I've no idea how to avoid this properly at the moment, but this is
 		// an admin tool so you're not injecting SQL right?
-		rows, err = server.db.Query(fmt.Sprintf("SELECT * FROM %s;", namespace)) // nolint: gas, safesql
+		rows, err = server.db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s;", namespace)) // nolint: gas, safesql
 	} else {
-		rows, err = server.db.Query(query) // nolint: safesql
+		rows, err = server.db.QueryContext(ctx, query) // nolint: safesql
 	}
 	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return []prometheus.Metric{}, []error{}, &ErrorQueryTimeout{namespace, mapping.timeout}
+		}
 		return []prometheus.Metric{}, []error{}, fmt.Errorf("Error running query on database %q: %s %v", server, namespace, err)
 	}
 	defer rows.Close() // nolint: errcheck
@@ -1293,7 +2157,27 @@ func queryNamespaceMapping(server *Server, namespace string, mapping MetricMapNa
 
 	metrics := make([]prometheus.Metric, 0)
 
+	histograms := make(map[string]*histogramRows)
+	for columnName, metricMapping := range mapping.columnMappings {
+		if !metricMapping.histogram {
+			continue
+		}
+		h, err := newHistogramRows(namespace, columnName, metricMapping, columnIdx)
+		if err != nil {
+			nonfatalErrors = append(nonfatalErrors, err)
+			continue
+		}
+		histograms[columnName] = h
+	}
+
+	var rowCount uint64
 	for rows.Next() {
+		if mapping.maxRows > 0 && rowCount >= mapping.maxRows {
+			nonfatalErrors = append(nonfatalErrors, &ErrorRowLimitExceeded{namespace, mapping.maxRows})
+			break
+		}
+		rowCount++
+
 		err = rows.Scan(scanArgs...)
 		if err != nil {
 			return []prometheus.Metric{}, []error{}, errors.New(fmt.Sprintln("Error retrieving rows:", namespace, err))
@@ -1305,6 +2189,12 @@ func queryNamespaceMapping(server *Server, namespace string, mapping MetricMapNa
 			labels[idx], _ = dbToString(columnData[columnIdx[label]])
 		}
 
+		for columnName, h := range histograms {
+			if err := h.add(columnData, labels); err != nil {
+				nonfatalErrors = append(nonfatalErrors, fmt.Errorf("HISTOGRAM column %s of %s: %v", columnName, namespace, err))
+			}
+		}
+
 		// Loop over column names, and match to scan data. Unknown columns
 		// will be filled with an untyped metric number *if* they can be
 		// converted to float64s. NULLs are allowed and treated as NaN.
@@ -1322,7 +2212,7 @@ func queryNamespaceMapping(server *Server, namespace string, mapping MetricMapNa
 					continue
 				}
 				// Generate the metric
-				metric = prometheus.MustNewConstMetric(metricMapping.desc, metricMapping.vtype, value, labels...)
+				metric = prometheus.MustNewConstMetric(metricMapping.desc, metricMapping.vtype, metricMapping.transform.apply(value), labels...)
 			} else {
 				// Unknown metric. Report as untyped if scan to float64 works, else note an error too.
 				metricLabel := fmt.Sprintf("%s_%s", namespace, columnName)
@@ -1340,9 +2230,27 @@ func queryNamespaceMapping(server *Server, namespace string, mapping MetricMapNa
 			metrics = append(metrics, metric)
 		}
 	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return []prometheus.Metric{}, []error{}, &ErrorQueryTimeout{namespace, mapping.timeout}
+	}
+	for _, h := range histograms {
+		metrics = append(metrics, h.metrics()...)
+	}
 	return metrics, nonfatalErrors, nil
 }
 
+// observeUserQueryDuration records the duration of a user query, with an
+// exemplar carrying the server it ran on for OpenMetrics scrapes, as the
+// histogram isn't labeled by server. The exemplar is left out if the server
+// exceeds the size allowed for exemplar labels.
+func observeUserQueryDuration(observer prometheus.Observer, server string, duration time.Duration) {
+	if len(serverLabelName)+utf8.RuneCountInString(server) > prometheus.ExemplarMaxRunes {
+		observer.Observe(duration.Seconds())
+		return
+	}
+	observer.(prometheus.ExemplarObserver).ObserveWithExemplar(duration.Seconds(), prometheus.Labels{serverLabelName: server})
+}
+
 // Iterate through all the namespace mappings in the exporter and run their
 // queries.
 func queryNamespaceMappings(ch chan<- prometheus.Metric, server *Server) map[string]error {
@@ -1377,7 +2285,13 @@ func queryNamespaceMappings(ch chan<- prometheus.Metric, server *Server) map[str
 		var nonFatalErrors []error
 		var err error
 		if scrapeMetric {
+			server.queryLimiter.acquire(server.String())
+			queryStart := time.Now()
 			metrics, nonFatalErrors, err = queryNamespaceMapping(server, namespace, mapping)
+			server.queryLimiter.release(server.String())
+			if mapping.resolution != "" && server.userQueryDuration != nil {
+				observeUserQueryDuration(server.userQueryDuration.WithLabelValues(namespace, string(mapping.resolution)), server.String(), time.Since(queryStart))
+			}
 		} else {
 			metrics = cachedMetric.metrics
 		}
@@ -1386,13 +2300,25 @@ func queryNamespaceMappings(ch chan<- prometheus.Metric, server *Server) map[str
 		if err != nil {
 			namespaceErrors[namespace] = err
 			log.Infoln(err)
+
+			if _, ok := err.(*ErrorQueryTimeout); ok && server.userQueryTimeouts != nil {
+				server.userQueryTimeouts.WithLabelValues(namespace).Inc()
+			}
 		}
 		// Non-serious errors - likely version or parsing problems.
+		rowLimitExceeded := 0.0
 		if len(nonFatalErrors) > 0 {
 			for _, err := range nonFatalErrors {
 				log.Infoln(err.Error())
+
+				if _, ok := err.(*ErrorRowLimitExceeded); ok {
+					rowLimitExceeded = 1
+				}
 			}
 		}
+		if scrapeMetric && mapping.maxRows > 0 && server.userQueryRowLimitExceeded != nil {
+			server.userQueryRowLimitExceeded.WithLabelValues(namespace).Set(rowLimitExceeded)
+		}
 
 		// Emit the metrics into the channel
 		for _, metric := range metrics {
@@ -1415,19 +2341,75 @@ func queryNamespaceMappings(ch chan<- prometheus.Metric, server *Server) map[str
 	return namespaceErrors
 }
 
-// Check and update the exporters query maps if the version has changed.
-func (e *Exporter) checkMapVersions(ch chan<- prometheus.Metric, server *Server) error {
-	log.Debugf("Querying Postgres Version on %q", server)
-	versionRow := server.db.QueryRow("SELECT version();")
+// queryVersion returns the version() string of the server. Forks may report
+// a version() string parseVersion doesn't understand, the version is then
+// built from the server_version setting, or else from server_version_num.
+func queryVersion(server *Server) (string, error) {
 	var versionString string
-	err := versionRow.Scan(&versionString)
+	if err := server.db.QueryRow("SELECT version();").Scan(&versionString); err != nil {
+		return "", fmt.Errorf("error scanning version string on %q: %v", server, err)
+	}
+	if _, err := parseVersion(versionString); err == nil {
+		return versionString, nil
+	}
+
+	var setting string
+	if err := server.db.QueryRow("SHOW server_version;").Scan(&setting); err != nil {
+		return "", fmt.Errorf("error scanning server_version on %q: %v", server, err)
+	}
+	if submatches := settingVersionRegex.FindStringSubmatch(setting); len(submatches) > 1 {
+		log.Debugf("Using server_version %q on %q, the version string %q can't be parsed", setting, server, versionString)
+		return "PostgreSQL " + submatches[1], nil
+	}
+
+	if err := server.db.QueryRow("SHOW server_version_num;").Scan(&setting); err != nil {
+		return "", fmt.Errorf("error scanning server_version_num on %q: %v", server, err)
+	}
+	version, err := parseVersionNum(setting)
 	if err != nil {
-		return fmt.Errorf("error scanning version string on %q: %v", server, err)
+		return "", fmt.Errorf("error parsing server_version_num on %q: %v", server, err)
+	}
+	log.Debugf("Using server_version_num %q on %q, the version string %q can't be parsed", setting, server, versionString)
+	return "PostgreSQL " + version.String(), nil
+}
+
+// versionChanged records the version of the server, and returns whether it
+// differs from the one of its previous scrape. The versions are kept by
+// fingerprint rather than on the Server, which is replaced when the
+// connection fails, e.g. because the server restarted after an upgrade.
+func (e *Exporter) versionChanged(server *Server, version semver.Version) bool {
+	e.serverVersionsMtx.Lock()
+	defer e.serverVersionsMtx.Unlock()
+
+	previous, ok := e.serverVersions[server.String()]
+	e.serverVersions[server.String()] = version
+	if ok && previous.NE(version) {
+		log.Warnf("PostgreSQL version changed on %q: %s -> %s", server, previous, version)
+		return true
+	}
+	return false
+}
+
+// Check and update the exporters query maps if the version has changed.
+func (e *Exporter) checkMapVersions(ch chan<- prometheus.Metric, server *Server) error {
+	versionString, ok := server.versionCache.get(server.String())
+	if !ok {
+		log.Debugf("Querying Postgres Version on %q", server)
+		var err error
+		if versionString, err = queryVersion(server); err != nil {
+			return err
+		}
+		server.versionCache.set(server.String(), versionString)
 	}
 	semanticVersion, err := parseVersion(versionString)
 	if err != nil {
 		return fmt.Errorf("error parsing version string on %q: %v", server, err)
 	}
+	// The discovered databases share the server label, only the master
+	// counts the changes.
+	if server.master && e.versionChanged(server, semanticVersion) {
+		e.versionChanges.WithLabelValues(server.String()).Inc()
+	}
 	if !e.disableDefaultMetrics && semanticVersion.LT(lowestSupportedVersion) {
 		log.Warnf("PostgreSQL version is lower on %q then our lowest supported version! Got %s minimum supported is %s.", server, semanticVersion, lowestSupportedVersion)
 	}
@@ -1475,26 +2457,148 @@ func (e *Exporter) checkMapVersions(ch chan<- prometheus.Metric, server *Server)
 
 func (e *Exporter) loadCustomQueries(res MetricResolution, version semver.Version, server *Server) {
 	if e.userQueriesPath[res] != "" {
-		fi, err := ioutil.ReadDir(e.userQueriesPath[res])
+		files, err := customQueriesFiles(e.userQueriesPath[res], e.userQueriesExclude[res])
 		if err != nil {
 			log.Errorf("failed read dir %q for custom query. reason: %s", e.userQueriesPath[res], err)
 			return
 		}
 
-		for _, v := range fi {
-			if v.IsDir() {
-				continue
+		for _, path := range files {
+			e.addCustomQueriesFromFile(path, res, version, server)
+		}
+	}
+}
+
+// customQueriesFiles returns the paths of the YAML files in dir and its
+// subdirectories, except the ones matching the exclude glob pattern. The
+// pattern is matched against both the path relative to dir and the file name.
+// Hidden files and directories are skipped, e.g. the ..data directories of
+// the Kubernetes ConfigMap volumes, which hold the same files again.
+func customQueriesFiles(dir, exclude string) ([]string, error) {
+	if _, err := filepath.Match(exclude, ""); err != nil {
+		return nil, fmt.Errorf("invalid exclude pattern %q: %v", exclude, err)
+	}
+
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if filepath.Ext(path) != ".yml" && filepath.Ext(path) != ".yaml" {
+			return nil
+		}
 
-			if filepath.Ext(v.Name()) == ".yml" || filepath.Ext(v.Name()) == ".yaml" {
-				path := filepath.Join(e.userQueriesPath[res], v.Name())
-				e.addCustomQueriesFromFile(path, version, server)
+		if exclude != "" {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			relMatch, _ := filepath.Match(exclude, rel)
+			nameMatch, _ := filepath.Match(exclude, info.Name())
+			if relMatch || nameMatch {
+				log.Debugln("Skipping excluded custom queries file", path)
+				return nil
 			}
 		}
+
+		files = append(files, path)
+		return nil
+	})
+	return files, err
+}
+
+// customQueriesSummary counts the custom query files and queries of a resolution.
+type customQueriesSummary struct {
+	Files   int `json:"files"`
+	Queries int `json:"queries"`
+}
+
+// reloadCustomQueries parses the custom query files of the enabled
+// resolutions, and makes every server reload them on its next scrape.
+// Nothing is reloaded if a file can't be read or parsed.
+func (e *Exporter) reloadCustomQueries() (map[MetricResolution]customQueriesSummary, error) {
+	summary := make(map[MetricResolution]customQueriesSummary)
+	for res, dir := range e.userQueriesPath {
+		if dir == "" || !e.userQueriesEnabled[res] {
+			continue
+		}
+
+		files, err := customQueriesFiles(dir, e.userQueriesExclude[res])
+		if err != nil {
+			return nil, err
+		}
+
+		var resSummary customQueriesSummary
+		for _, path := range files {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			_, queries, _, err := parseUserQueries(content)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+			}
+			resSummary.Files++
+			resSummary.Queries += len(queries)
+		}
+		summary[res] = resSummary
 	}
+
+	e.servers.InvalidateMaps()
+	return summary, nil
 }
 
-func (e *Exporter) addCustomQueriesFromFile(path string, version semver.Version, server *Server) {
+// unsafeQueryRegex matches the statements which write or change the schema,
+// which have no place in the queries of an exporter. INTO alone is SELECT
+// INTO, which creates a table. String literals are removed before matching,
+// see unsafeQueryKeyword.
+var unsafeQueryRegex = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|MERGE|TRUNCATE|DROP|CREATE|ALTER|GRANT|REVOKE|COPY|VACUUM|ANALYZE|REINDEX|CLUSTER|LOCK\s+TABLE|REFRESH\s+MATERIALIZED\s+VIEW|INTO)\b`)
+
+// sqlStringRegex matches the string literals of SQL.
+var sqlStringRegex = regexp.MustCompile(`'(?:[^']|'')*'`)
+
+// unsafeQueryKeyword returns the statement of unsafeQueryRegex found in the
+// query, or an empty string if it's safe.
+func unsafeQueryKeyword(query string) string {
+	match := unsafeQueryRegex.FindStringSubmatch(sqlStringRegex.ReplaceAllString(query, "''"))
+	if match == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(strings.ToUpper(match[1])), " ")
+}
+
+// checkUnsafeQueries returns an error naming the first query of the user's
+// queries, by name, with a statement matched by unsafeQueryRegex.
+func checkUnsafeQueries(content []byte) error {
+	_, queries, _, err := parseUserQueries(content)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(queries))
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if keyword := unsafeQueryKeyword(queries[name]); keyword != "" {
+			return fmt.Errorf("query %s contains %s", name, keyword)
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) addCustomQueriesFromFile(path string, res MetricResolution, version semver.Version, server *Server) {
 	// Calculate the hashsum of the useQueries
 	userQueriesData, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -1505,7 +2609,15 @@ func (e *Exporter) addCustomQueriesFromFile(path string, version semver.Version,
 
 	hashsumStr := fmt.Sprintf("%x", sha256.Sum256(userQueriesData))
 
-	if err := addQueries(userQueriesData, version, server); err != nil {
+	if e.rejectUnsafeQuery {
+		if err := checkUnsafeQueries(userQueriesData); err != nil {
+			log.Errorln("Refusing to load user queries:", path, err)
+			e.userQueriesError.WithLabelValues(path, hashsumStr).Set(1)
+			return
+		}
+	}
+
+	if err := addQueries(userQueriesData, version, server, res); err != nil {
 		log.Errorln("Failed to reload user queries:", path, err)
 		e.userQueriesError.WithLabelValues(path, hashsumStr).Set(1)
 		return
@@ -1522,7 +2634,7 @@ func (e *Exporter) scrape(ch chan<- prometheus.Metric) {
 
 	e.totalScrapes.Inc()
 
-	dsns := e.dsn
+	dsns := e.dataSources()
 	if e.autoDiscoverDatabases {
 		dsns = e.discoverDatabaseDSNs()
 	}
@@ -1530,9 +2642,16 @@ func (e *Exporter) scrape(ch chan<- prometheus.Metric) {
 	var errorsCount int
 	var connectionErrorsCount int
 
-	for _, dsn := range dsns {
-		if err := e.scrapeDSN(ch, dsn); err != nil {
+	for i, result := range e.scrapeDSNs(dsns, e.scrapeDSN) {
+		dsn, err := dsns[i], result.err
+		for _, metric := range result.metrics {
+			ch <- metric
+		}
+
+		databaseUp := 1.0
+		if err != nil {
 			errorsCount++
+			databaseUp = 0
 
 			log.Errorf(err.Error())
 
@@ -1540,6 +2659,10 @@ func (e *Exporter) scrape(ch chan<- prometheus.Metric) {
 				connectionErrorsCount++
 			}
 		}
+
+		if e.autoDiscoverDatabases {
+			e.emitDatabaseUp(ch, dsn, databaseUp)
+		}
 	}
 
 	switch {
@@ -1557,9 +2680,109 @@ func (e *Exporter) scrape(ch chan<- prometheus.Metric) {
 	}
 }
 
+// emitDatabaseUp reports whether the database of a DSN could be scraped,
+// without connection, query or collector errors.
+func (e *Exporter) emitDatabaseUp(ch chan<- prometheus.Metric, dsn string, up float64) {
+	kv, err := parseDSN(dsn)
+	if err != nil {
+		log.Errorf("Unable to parse DSN (%s): %v", loggableDSN(dsn), err)
+		return
+	}
+	fingerprint, _ := parseFingerprint(dsn)
+
+	// Without a database name PostgreSQL connects to the database of the user.
+	datname, ok := kv["dbname"]
+	if !ok {
+		datname = kv["user"]
+	}
+
+	ch <- prometheus.MustNewConstMetric(e.databaseUp, prometheus.GaugeValue, up, datname, fingerprint)
+}
+
+// dsnScrape is the outcome of the scrape of a DSN.
+type dsnScrape struct {
+	metrics []prometheus.Metric
+	err     error
+}
+
+// scrapeDSNs scrapes the DSNs with scrape concurrently, at most e.maxConcurrency of them
+// at the same time, and returns their results in the order of the DSNs, so
+// that the metrics of a server are emitted together and in order.
+func (e *Exporter) scrapeDSNs(dsns []string, scrape func(chan<- prometheus.Metric, string) error) []dsnScrape {
+	results := make([]dsnScrape, len(dsns))
+
+	workers := e.maxConcurrency
+	if workers <= 0 || workers > len(dsns) {
+		workers = len(dsns)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = e.bufferedScrapeDSN(dsns[i], scrape)
+			}
+		}()
+	}
+	for i := range dsns {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// bufferedScrapeDSN scrapes a DSN with scrape and returns its metrics. A panic during the
+// scrape is returned as an error, so that it doesn't affect the other DSNs.
+func (e *Exporter) bufferedScrapeDSN(dsn string, scrape func(chan<- prometheus.Metric, string) error) (result dsnScrape) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var metrics []prometheus.Metric
+	go func() {
+		for metric := range ch {
+			metrics = append(metrics, metric)
+		}
+		close(done)
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			result.err = fmt.Errorf("panic while scraping %s: %v", loggableDSN(dsn), r)
+		}
+		close(ch)
+		<-done
+		result.metrics = metrics
+	}()
+
+	e.acquireTarget()
+	defer e.releaseTarget()
+
+	result.err = scrape(ch, dsn)
+	return result
+}
+
+// acquireTarget blocks until another database may be scraped. Every call must
+// be followed by releaseTarget.
+func (e *Exporter) acquireTarget() {
+	if e.targetSlots != nil {
+		e.targetSlots <- struct{}{}
+	}
+}
+
+func (e *Exporter) releaseTarget() {
+	if e.targetSlots != nil {
+		<-e.targetSlots
+	}
+}
+
 func (e *Exporter) discoverDatabaseDSNs() []string {
 	dsns := make(map[string]struct{})
-	for _, dsn := range e.dsn {
+	truncated := false
+	for _, dsn := range e.dataSources() {
 		parsedDSN, err := url.Parse(dsn)
 		if err != nil {
 			log.Errorf("Unable to parse DSN (%s): %v", loggableDSN(dsn), err)
@@ -1581,15 +2804,23 @@ func (e *Exporter) discoverDatabaseDSNs() []string {
 			log.Errorf("Error querying databases (%s): %v", loggableDSN(dsn), err)
 			continue
 		}
+		databaseNames, cut := e.selectDatabases(databaseNames)
+		if cut {
+			log.Warnf("Scraping only %d of the databases of %s, as set by --auto-discover.max-databases", len(databaseNames), loggableDSN(dsn))
+			truncated = true
+		}
 		for _, databaseName := range databaseNames {
-			if contains(e.excludeDatabases, databaseName) {
-				continue
-			}
 			parsedDSN.Path = databaseName
 			dsns[parsedDSN.String()] = struct{}{}
 		}
 	}
 
+	if truncated {
+		e.databasesTruncated.Set(1)
+	} else {
+		e.databasesTruncated.Set(0)
+	}
+
 	result := make([]string, len(dsns))
 	index := 0
 	for dsn := range dsns {
@@ -1600,6 +2831,24 @@ func (e *Exporter) discoverDatabaseDSNs() []string {
 	return result
 }
 
+// selectDatabases removes the excluded databases from the discovered ones and
+// keeps the first maxDatabases by name. It reports whether any was dropped
+// by the limit.
+func (e *Exporter) selectDatabases(databaseNames []string) ([]string, bool) {
+	result := make([]string, 0, len(databaseNames))
+	for _, databaseName := range databaseNames {
+		if !contains(e.excludeDatabases, databaseName) {
+			result = append(result, databaseName)
+		}
+	}
+	sort.Strings(result)
+
+	if e.maxDatabases > 0 && len(result) > e.maxDatabases {
+		return result[:e.maxDatabases], true
+	}
+	return result, false
+}
+
 func (e *Exporter) scrapeDSN(ch chan<- prometheus.Metric, dsn string) error {
 	server, err := e.servers.GetServer(dsn)
 
@@ -1617,7 +2866,22 @@ func (e *Exporter) scrapeDSN(ch chan<- prometheus.Metric, dsn string) error {
 		log.Warnln("Proceeding with outdated query maps, as the Postgres version could not be determined:", err)
 	}
 
-	return server.Scrape(ch, e.disableSettingsMetrics)
+	err = server.Scrape(ch, e.disableSettingsMetrics)
+
+	collectorErrors := e.runCollectors(ch, server)
+	for _, cerr := range collectorErrors {
+		log.Infoln(cerr)
+	}
+	if len(collectorErrors) > 0 {
+		// Keep the error of the query mappings, if any.
+		if err != nil {
+			err = fmt.Errorf("%v; collectors returned %d errors", err, len(collectorErrors))
+		} else {
+			err = fmt.Errorf("collectors returned %d errors", len(collectorErrors))
+		}
+	}
+
+	return err
 }
 
 // try to get the DataSource
@@ -1670,6 +2934,93 @@ func getDataSources() []string {
 	return strings.Split(dsn, ",")
 }
 
+// readDataSourceFile reads a file with one DSN per line. Empty lines and lines
+// starting with # are skipped.
+func readDataSourceFile(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var dsns []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dsns = append(dsns, line)
+	}
+	return dsns, nil
+}
+
+// dataSources returns the DSNs given to NewExporter followed by those of the
+// data source file.
+func (e *Exporter) dataSources() []string {
+	e.dsnMtx.RLock()
+	defer e.dsnMtx.RUnlock()
+
+	dsns := make([]string, 0, len(e.dsn)+len(e.fileDSN))
+	dsns = append(dsns, e.dsn...)
+	return append(dsns, e.fileDSN...)
+}
+
+// reloadDataSourceFile reads the data source file again, if any, and returns
+// the number of DSNs it contains. The DSNs are kept if it can't be read.
+func (e *Exporter) reloadDataSourceFile() (int, error) {
+	if e.dataSourceFile == "" {
+		return 0, nil
+	}
+
+	dsns, err := readDataSourceFile(e.dataSourceFile)
+	if err != nil {
+		return 0, err
+	}
+
+	e.dsnMtx.Lock()
+	previous := e.fileDSN
+	e.fileDSN = dsns
+	e.dsnMtx.Unlock()
+
+	e.removeServers(previous)
+	return len(dsns), nil
+}
+
+// removeServers closes the connections to the DSNs of the previous data
+// source file which aren't scraped anymore, and to the databases discovered
+// on their servers, unless another DSN still scrapes the same server.
+func (e *Exporter) removeServers(previous []string) {
+	current := e.dataSources()
+	scraped := make(map[string]bool)
+	for _, dsn := range current {
+		if fingerprint, err := parseFingerprint(dsn); err == nil {
+			scraped[fingerprint] = true
+		}
+	}
+
+	var removed []string
+	removedServers := make(map[string]bool)
+	for _, dsn := range previous {
+		if contains(current, dsn) {
+			continue
+		}
+		removed = append(removed, dsn)
+		if fingerprint, err := parseFingerprint(dsn); err == nil && !scraped[fingerprint] {
+			removedServers[fingerprint] = true
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+
+	e.servers.Retain(func(dsn string) bool {
+		if contains(removed, dsn) {
+			return false
+		}
+		fingerprint, err := parseFingerprint(dsn)
+		return err != nil || !removedServers[fingerprint]
+	})
+}
+
 func contains(a []string, x string) bool {
 	for _, n := range a {
 		if x == n {
@@ -1682,7 +3033,7 @@ func contains(a []string, x string) bool {
 func main() {
 	kingpin.Version(fmt.Sprintf("postgres_exporter %s (built with %s)\n", Version, runtime.Version()))
 	log.AddFlags(kingpin.CommandLine)
-	kingpin.Parse()
+	command := kingpin.Parse()
 
 	log.Infoln("Starting postgres_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
@@ -1693,8 +3044,23 @@ func main() {
 	}
 
 	dsn := getDataSources()
-	if len(dsn) == 0 {
-		log.Fatal("couldn't find environment variables describing the datasource to use")
+	if *dataSourceFile != "" && os.Getenv("DATA_SOURCE_NAME") == "" && os.Getenv("DATA_SOURCE_URI") == "" && os.Getenv("DATA_SOURCE_URI_FILE") == "" {
+		// Without a DSN in the environment, only those of the file are used.
+		dsn = nil
+	}
+
+	dsnParams := map[string]string{
+		"sslmode":     *dbSSLMode,
+		"sslcert":     *dbSSLCert,
+		"sslkey":      *dbSSLKey,
+		"sslrootcert": *dbSSLRootCert,
+	}
+	for _, param := range []string{"sslcert", "sslkey", "sslrootcert"} {
+		if path := dsnParams[param]; path != "" {
+			if _, err := os.Stat(path); err != nil {
+				log.Fatalf("Can't use --db.%s: %s", param, err)
+			}
+		}
 	}
 
 	queriesEnabled := map[MetricResolution]bool{
@@ -1709,19 +3075,87 @@ func main() {
 		LR: *collectCustomQueryLrDirectory,
 	}
 
+	queriesExclude := map[MetricResolution]string{
+		HR: *collectCustomQueryHrExclude,
+		MR: *collectCustomQueryMrExclude,
+		LR: *collectCustomQueryLrExclude,
+	}
+
 	exporter := NewExporter(dsn,
 		DisableDefaultMetrics(*disableDefaultMetrics),
 		DisableSettingsMetrics(*disableSettingsMetrics),
 		AutoDiscoverDatabases(*autoDiscoverDatabases),
 		WithUserQueriesEnabled(queriesEnabled),
 		WithUserQueriesPath(queriesPath),
+		WithUserQueriesExclude(queriesExclude),
+		WithUserQueriesMaxRows(*collectCustomQueryMaxRows),
+		WithRejectUnsafeQueries(*rejectUnsafeQueries),
 		WithConstantLabels(*constantLabelsList),
 		ExcludeDatabases(*excludeDatabases),
+		WithMaxDatabases(*autoDiscoverMaxDatabases),
+		WithDriver(*dbDriver),
+		WithTLSServerName(*dbTLSServerName),
+		WithInitSQL(*dbInitSQL),
+		WithSessionTimeouts(*dbStatementTimeout, *dbLockTimeout),
+		WithInstanceLabelsQuery(*dbInstanceLabelsQuery),
+		WithDataSourceFile(*dataSourceFile),
+		WithDSNParams(dsnParams),
+		WithMaxConnections(*dbMaxOpenConns, *dbMaxIdleConns),
+		WithMaxTargetQueries(*dbMaxConcurrentQueries),
+		WithCollectors(enabledCollectors()),
+		WithCollectorTimeout(*collectorTimeout),
+		WithCollectorRetries(*collectorRetries),
+		WithMaxParallelTargets(*maxParallelTargets),
+		WithMaxConcurrency(*maxConcurrency),
+		WithVersionCacheTTL(*versionCacheTTL),
 	)
 	defer func() {
 		exporter.servers.Close()
 	}()
 
+	if *onlyCheckQueries {
+		errs := exporter.checkCustomQueries()
+		for _, err := range errs {
+			log.Errorln(err)
+		}
+		if len(errs) > 0 {
+			log.Fatalf("The custom queries are invalid, %d errors found.", len(errs))
+		}
+		log.Infoln("The custom queries are valid.")
+		return
+	}
+
+	if command == checkConfigCommand.FullCommand() {
+		errs := checkConfig(exporter, *probeAuthFile)
+		for _, err := range errs {
+			log.Errorln(err)
+		}
+		if len(errs) > 0 {
+			log.Fatalf("The configuration is invalid, %d errors found.", len(errs))
+		}
+		log.Infoln("The configuration is valid.")
+		return
+	}
+
+	probeConfig, err := readProbeConfig(*probeAuthFile)
+	if err != nil {
+		log.Fatalf("Can't read --probe.auth-file: %s", err)
+	}
+	relabelConfigs, err := readRelabelConfigs(*relabelConfigFile)
+	if err != nil {
+		log.Fatalf("Can't read --config.relabel-file: %s", err)
+	}
+	if len(dsn) == 0 && *dataSourceFile == "" && *probeAuthFile == "" {
+		log.Fatal("couldn't find environment variables describing the datasource to use")
+	}
+
+	if _, err := exporter.reloadDataSourceFile(); err != nil {
+		log.Fatalf("Can't read --config.data-source-file: %s", err)
+	}
+	if err := checkSettingsCollector(exporter); err != nil {
+		log.Fatal(err)
+	}
+
 	prometheus.MustRegister(exporter)
 
 	version.Branch = Branch
@@ -1733,11 +3167,62 @@ func main() {
 	psCollector := prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{})
 	goCollector := prometheus.NewGoCollector()
 
-	exporter_shared.RunServer("PostgreSQL", *listenAddress, *metricPath, newHandler(map[string]prometheus.Collector{
-		"exporter":         exporter,
-		"standard.process": psCollector,
-		"standard.go":      goCollector,
-	}))
+	handlers := map[string]http.Handler{
+		*metricPath: newHandler(map[string]prometheus.Collector{
+			"exporter":         exporter,
+			"standard.process": psCollector,
+			"standard.go":      goCollector,
+		}, relabelConfigs),
+		"/reload": newReloadHandler(exporter),
+		"/probe":  newProbeHandler(exporter, probeConfig, relabelConfigs),
+	}
+	if *enableLogLevel {
+		handlers["/-/log-level"] = newLogLevelHandler(log.Base())
+	}
+	runServer("PostgreSQL", *listenAddress, *metricPath, handlers)
+}
+
+// reloadHandler reloads the data source file and the custom queries on POST
+// requests, and responds with the number of files and queries loaded per
+// resolution.
+type reloadHandler struct {
+	exporter *Exporter
+}
+
+func newReloadHandler(exporter *Exporter) *reloadHandler {
+	return &reloadHandler{exporter: exporter}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *reloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	dsns, err := h.exporter.reloadDataSourceFile()
+	if err != nil {
+		log.Errorln("Failed to reload the data source file:", err)
+		http.Error(w, fmt.Sprintf("Failed to reload the data source file: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if h.exporter.dataSourceFile != "" {
+		log.Infof("Data source file reloaded, %d DSNs.", dsns)
+	}
+
+	summary, err := h.exporter.reloadCustomQueries()
+	if err != nil {
+		log.Errorln("Failed to reload custom queries:", err)
+		http.Error(w, fmt.Sprintf("Failed to reload custom queries: %s", err), http.StatusInternalServerError)
+		return
+	}
+	log.Infoln("Custom queries reloaded.")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		log.Errorln("Failed to write reload response:", err)
+	}
 }
 
 // handler wraps an unfiltered http.Handler but uses a filtered handler,
@@ -1746,10 +3231,11 @@ func main() {
 type handler struct {
 	unfilteredHandler http.Handler
 	collectors        map[string]prometheus.Collector
+	relabelConfigs    []*relabelConfig
 }
 
-func newHandler(collectors map[string]prometheus.Collector) *handler {
-	h := &handler{collectors: collectors}
+func newHandler(collectors map[string]prometheus.Collector, relabelConfigs []*relabelConfig) *handler {
+	h := &handler{collectors: collectors, relabelConfigs: relabelConfigs}
 
 	innerHandler, err := h.innerHandler()
 	if err != nil {
@@ -1806,10 +3292,11 @@ func (h *handler) innerHandler(filters ...string) (http.Handler, error) {
 	}
 
 	handler := promhttp.HandlerFor(
-		registry,
+		newRelabelGatherer(registry, h.relabelConfigs),
 		promhttp.HandlerOpts{
-			ErrorLog:      log.NewErrorLogger(),
-			ErrorHandling: promhttp.ContinueOnError,
+			ErrorLog:          log.NewErrorLogger(),
+			ErrorHandling:     promhttp.ContinueOnError,
+			EnableOpenMetrics: *enableOpenMetrics,
 		},
 	)
 
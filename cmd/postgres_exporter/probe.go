@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+var probeAuthFile = kingpin.Flag("probe.auth-file", "Path to a YAML file with the auth modules used to connect to the targets of /probe.").Default("").Envar("PG_EXPORTER_PROBE_AUTH_FILE").String()
+
+// probeConfig is the content of the --probe.auth-file file.
+type probeConfig struct {
+	AuthModules map[string]authModule `yaml:"auth_modules"`
+}
+
+// authModule holds the credentials and connection parameters used to connect
+// to the targets of /probe.
+type authModule struct {
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	Options  map[string]string `yaml:"options"`
+}
+
+// readProbeConfig reads the auth modules of /probe. An empty path returns an
+// empty configuration.
+func readProbeConfig(path string) (*probeConfig, error) {
+	config := &probeConfig{}
+	if path == "" {
+		return config, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// authModule returns the named auth module. The name may be omitted if there
+// is a single module.
+func (c *probeConfig) authModule(name string) (authModule, error) {
+	if name == "" && len(c.AuthModules) <= 1 {
+		for _, module := range c.AuthModules {
+			return module, nil
+		}
+		return authModule{}, nil
+	}
+
+	module, ok := c.AuthModules[name]
+	if !ok {
+		return authModule{}, fmt.Errorf("unknown auth module %q", name)
+	}
+	return module, nil
+}
+
+// dsn returns the DSN of the database on the target, a host:port address.
+func (m authModule) dsn(target, database string) string {
+	u := url.URL{
+		Scheme: "postgresql",
+		Host:   target,
+		Path:   "/" + database,
+	}
+	if m.Username != "" {
+		u.User = url.UserPassword(m.Username, m.Password)
+	}
+
+	query := url.Values{}
+	for key, value := range m.Options {
+		query.Set(key, value)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// probeHandler scrapes the target given by the URL parameters, like the
+// blackbox_exporter does, with a connection which is closed afterwards.
+type probeHandler struct {
+	exporter *Exporter
+	config   *probeConfig
+	// Relabeling rules of --config.relabel-file.
+	relabelConfigs []*relabelConfig
+}
+
+func newProbeHandler(exporter *Exporter, config *probeConfig, relabelConfigs []*relabelConfig) *probeHandler {
+	return &probeHandler{exporter: exporter, config: config, relabelConfigs: relabelConfigs}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	database := params.Get("database")
+	if database == "" {
+		database = "postgres"
+	}
+
+	module, err := h.config.authModule(params.Get("auth_module"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := append(h.exporter.serverOpts(), ServerWithVersionCache(h.exporter.versionCache))
+	server, err := NewServer(module.dsn(target, database), opts...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create a connection to %s: %s", target, err), http.StatusBadRequest)
+		return
+	}
+	defer server.Close() // nolint: errcheck
+	server.master = true
+	server.probe = true
+	server.collectors = h.exporter.probeCollectors.get(server.String()+"/"+database, server.collectors)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&probeCollector{exporter: h.exporter, server: server})
+	handler := promhttp.HandlerFor(
+		newRelabelGatherer(registry, h.relabelConfigs),
+		promhttp.HandlerOpts{
+			ErrorLog:          log.NewErrorLogger(),
+			ErrorHandling:     promhttp.ContinueOnError,
+			EnableOpenMetrics: *enableOpenMetrics,
+		},
+	)
+	handler.ServeHTTP(w, r)
+}
+
+// defaultProbeCollectorTTL is how long the collectors of a /probe target are
+// kept after its last probe.
+const defaultProbeCollectorTTL = time.Hour
+
+// probeCollectorCache keeps the collector instances of the /probe targets,
+// keyed by fingerprint and database, as the server of a probe is created for
+// it. The collectors comparing a scrape with the previous one, e.g.
+// cluster_tps, need the same instance from one probe to the next.
+type probeCollectorCache struct {
+	ttl     time.Duration
+	mtx     sync.Mutex
+	entries map[string]*probeCollectorEntry
+	now     func() time.Time
+}
+
+type probeCollectorEntry struct {
+	collectors []serverCollector
+	lastUsed   time.Time
+}
+
+func newProbeCollectorCache(ttl time.Duration) *probeCollectorCache {
+	return &probeCollectorCache{
+		ttl:     ttl,
+		entries: make(map[string]*probeCollectorEntry),
+		now:     time.Now,
+	}
+}
+
+// get returns the collectors of the target, or keeps the given ones if it
+// wasn't probed within the TTL. The collectors of the targets which weren't
+// probed within the TTL are dropped.
+func (c *probeCollectorCache) get(key string, collectors []serverCollector) []serverCollector {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.now()
+	for k, entry := range c.entries {
+		if now.Sub(entry.lastUsed) > c.ttl {
+			delete(c.entries, k)
+		}
+	}
+
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &probeCollectorEntry{collectors: collectors}
+		c.entries[key] = entry
+	}
+	entry.lastUsed = now
+	return entry.collectors
+}
+
+// probeCollector collects the metrics of a single server for /probe.
+type probeCollector struct {
+	exporter *Exporter
+	server   *Server
+}
+
+// Describe implements prometheus.Collector. The collector is unchecked, as
+// the metrics depend on the server.
+func (c *probeCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector. The probes count towards
+// --scrape.max-parallel-targets like the databases of /metrics.
+func (c *probeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.exporter.acquireTarget()
+	defer c.exporter.releaseTarget()
+
+	up := 1.0
+	if err := c.server.Ping(); err != nil {
+		log.Errorf("Error connecting to %q: %v", c.server, err)
+		up = 0
+	} else {
+		if err := c.exporter.checkMapVersions(ch, c.server); err != nil {
+			log.Warnln("Proceeding with outdated query maps, as the Postgres version could not be determined:", err)
+		}
+		if err := c.server.Scrape(ch, c.exporter.disableSettingsMetrics); err != nil {
+			log.Errorf("Error scraping %q: %v", c.server, err)
+		}
+		for _, err := range c.exporter.runCollectors(ch, c.server) {
+			log.Infoln(err)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		newDesc("", "up", "Whether the probe was able to connect to the target (1 for yes, 0 for no).", c.server.labels),
+		prometheus.GaugeValue, up,
+	)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+var relabelConfigFile = kingpin.Flag("config.relabel-file", "Path to a YAML file with the metric_relabel_configs applied to the metrics of /metrics and /probe.").Default("").Envar("PG_EXPORTER_RELABEL_FILE").String()
+
+// Relabel actions, like those of the metric_relabel_configs of Prometheus.
+const (
+	relabelReplace   = "replace"
+	relabelKeep      = "keep"
+	relabelDrop      = "drop"
+	relabelLabelDrop = "labeldrop"
+)
+
+// relabelFile is the content of the --config.relabel-file file.
+type relabelFile struct {
+	MetricRelabelConfigs []*relabelConfig `yaml:"metric_relabel_configs"`
+}
+
+// relabelConfig is a relabeling rule, with the defaults of Prometheus.
+type relabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    string   `yaml:"separator"`
+	Regex        string   `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  string   `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+
+	regex *regexp.Regexp
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaller interface.
+func (rc *relabelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain relabelConfig
+	*rc = relabelConfig{Separator: ";", Regex: "(.*)", Replacement: "$1", Action: relabelReplace}
+	if err := unmarshal((*plain)(rc)); err != nil {
+		return err
+	}
+
+	regex, err := regexp.Compile("^(?:" + rc.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid regex %q: %s", rc.Regex, err)
+	}
+	rc.regex = regex
+
+	switch rc.Action {
+	case relabelReplace:
+		if rc.TargetLabel != model.MetricNameLabel && !model.LabelName(rc.TargetLabel).IsValid() {
+			return fmt.Errorf("invalid target_label %q for the %s action", rc.TargetLabel, rc.Action)
+		}
+	case relabelKeep, relabelDrop:
+		if len(rc.SourceLabels) == 0 {
+			return fmt.Errorf("source_labels are required for the %s action", rc.Action)
+		}
+	case relabelLabelDrop:
+		if len(rc.SourceLabels) > 0 || rc.TargetLabel != "" {
+			return fmt.Errorf("source_labels and target_label are not allowed for the %s action", rc.Action)
+		}
+	default:
+		return fmt.Errorf("unknown relabel action %q", rc.Action)
+	}
+	return nil
+}
+
+// readRelabelConfigs reads the rules of --config.relabel-file. An empty path
+// returns no rules.
+func readRelabelConfigs(path string) ([]*relabelConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file relabelFile
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, err
+	}
+	return file.MetricRelabelConfigs, nil
+}
+
+// relabel applies the rules in order to the labels of a metric, including its
+// name in __name__. It returns false if the metric is dropped.
+func relabel(labels map[string]string, configs []*relabelConfig) bool {
+	for _, rc := range configs {
+		values := make([]string, len(rc.SourceLabels))
+		for i, name := range rc.SourceLabels {
+			values[i] = labels[name]
+		}
+		value := strings.Join(values, rc.Separator)
+
+		switch rc.Action {
+		case relabelKeep:
+			if !rc.regex.MatchString(value) {
+				return false
+			}
+		case relabelDrop:
+			if rc.regex.MatchString(value) {
+				return false
+			}
+		case relabelLabelDrop:
+			for name := range labels {
+				if name != model.MetricNameLabel && rc.regex.MatchString(name) {
+					delete(labels, name)
+				}
+			}
+		case relabelReplace:
+			match := rc.regex.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			target := string(rc.regex.ExpandString(nil, rc.Replacement, value, match))
+			if target == "" {
+				delete(labels, rc.TargetLabel)
+				continue
+			}
+			labels[rc.TargetLabel] = target
+		}
+	}
+	return labels[model.MetricNameLabel] != ""
+}
+
+// relabelGatherer applies the relabeling rules to the metrics gathered by
+// another prometheus.Gatherer. The metrics renamed into the same name are
+// merged into one family, with the help and type of the first one.
+type relabelGatherer struct {
+	gatherer prometheus.Gatherer
+	configs  []*relabelConfig
+}
+
+// newRelabelGatherer returns the gatherer itself if there are no rules.
+func newRelabelGatherer(gatherer prometheus.Gatherer, configs []*relabelConfig) prometheus.Gatherer {
+	if len(configs) == 0 {
+		return gatherer
+	}
+	return &relabelGatherer{gatherer: gatherer, configs: configs}
+}
+
+// Gather implements prometheus.Gatherer. The metrics are relabeled even if
+// the wrapped gatherer returned an error with them.
+func (g *relabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+
+	byName := make(map[string]*dto.MetricFamily)
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			labels := map[string]string{model.MetricNameLabel: family.GetName()}
+			for _, pair := range metric.Label {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			if !relabel(labels, g.configs) {
+				continue
+			}
+
+			name := labels[model.MetricNameLabel]
+			delete(labels, model.MetricNameLabel)
+			relabeled, ok := byName[name]
+			if !ok {
+				relabeled = &dto.MetricFamily{Name: &name, Help: family.Help, Type: family.Type}
+				byName[name] = relabeled
+			}
+
+			metric.Label = make([]*dto.LabelPair, 0, len(labels))
+			for labelName, labelValue := range labels {
+				labelName, labelValue := labelName, labelValue
+				metric.Label = append(metric.Label, &dto.LabelPair{Name: &labelName, Value: &labelValue})
+			}
+			sort.Slice(metric.Label, func(i, j int) bool { return metric.Label[i].GetName() < metric.Label[j].GetName() })
+			relabeled.Metric = append(relabeled.Metric, metric)
+		}
+	}
+
+	result := make([]*dto.MetricFamily, 0, len(byName))
+	for _, family := range byName {
+		result = append(result, family)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].GetName() < result[j].GetName() })
+	return result, err
+}
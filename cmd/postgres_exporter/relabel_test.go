@@ -0,0 +1,154 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	. "gopkg.in/check.v1"
+	"gopkg.in/yaml.v2"
+)
+
+type RelabelSuite struct{}
+
+var _ = Suite(&RelabelSuite{})
+
+// relabelMetrics gathers the relabeled metrics of a registry with
+// pg_up{server}, and pg_locks_count{server,datname,mode} for two databases,
+// as name{labels} strings.
+func relabelMetrics(c *C, rules string) []string {
+	var file relabelFile
+	c.Assert(yaml.UnmarshalStrict([]byte(rules), &file), IsNil)
+
+	registry := prometheus.NewRegistry()
+	up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "pg_up", Help: "up", ConstLabels: prometheus.Labels{"server": "db:5432"}})
+	locks := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "pg_locks_count", Help: "locks", ConstLabels: prometheus.Labels{"server": "db:5432"}}, []string{"datname", "mode"})
+	locks.WithLabelValues("app", "accessexclusivelock").Set(1)
+	locks.WithLabelValues("postgres", "rowexclusivelock").Set(2)
+	registry.MustRegister(up, locks)
+
+	families, err := newRelabelGatherer(registry, file.MetricRelabelConfigs).Gather()
+	c.Assert(err, IsNil)
+
+	var metrics []string
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			metrics = append(metrics, family.GetName()+formatLabels(metric.Label))
+		}
+	}
+	return metrics
+}
+
+func formatLabels(pairs []*dto.LabelPair) string {
+	s := "{"
+	for i, pair := range pairs {
+		if i > 0 {
+			s += ","
+		}
+		s += pair.GetName() + "=" + pair.GetValue()
+	}
+	return s + "}"
+}
+
+func (s *RelabelSuite) TestNoRules(c *C) {
+	c.Assert(relabelMetrics(c, ""), DeepEquals, []string{
+		"pg_locks_count{datname=app,mode=accessexclusivelock,server=db:5432}",
+		"pg_locks_count{datname=postgres,mode=rowexclusivelock,server=db:5432}",
+		"pg_up{server=db:5432}",
+	})
+}
+
+func (s *RelabelSuite) TestKeep(c *C) {
+	c.Assert(relabelMetrics(c, `
+metric_relabel_configs:
+  - source_labels: [__name__, datname]
+    regex: "pg_locks_count;app|pg_up;"
+    action: keep
+`), DeepEquals, []string{
+		"pg_locks_count{datname=app,mode=accessexclusivelock,server=db:5432}",
+		"pg_up{server=db:5432}",
+	})
+}
+
+func (s *RelabelSuite) TestDrop(c *C) {
+	c.Assert(relabelMetrics(c, `
+metric_relabel_configs:
+  - source_labels: [__name__]
+    regex: "pg_locks_.*"
+    action: drop
+`), DeepEquals, []string{"pg_up{server=db:5432}"})
+}
+
+func (s *RelabelSuite) TestReplace(c *C) {
+	c.Assert(relabelMetrics(c, `
+metric_relabel_configs:
+  - source_labels: [server]
+    regex: "(.*):5432"
+    target_label: instance
+  - source_labels: [mode]
+    regex: "(.*)lock"
+    replacement: "${1}"
+    target_label: mode
+  - source_labels: [__name__]
+    regex: "pg_locks_count"
+    replacement: "pg_locks"
+    target_label: __name__
+`), DeepEquals, []string{
+		"pg_locks{datname=app,instance=db,mode=accessexclusive,server=db:5432}",
+		"pg_locks{datname=postgres,instance=db,mode=rowexclusive,server=db:5432}",
+		"pg_up{instance=db,server=db:5432}",
+	})
+
+	// An empty replacement removes the label.
+	c.Assert(relabelMetrics(c, `
+metric_relabel_configs:
+  - regex: ".*"
+    replacement: ""
+    target_label: server
+`), DeepEquals, []string{
+		"pg_locks_count{datname=app,mode=accessexclusivelock}",
+		"pg_locks_count{datname=postgres,mode=rowexclusivelock}",
+		"pg_up{}",
+	})
+}
+
+func (s *RelabelSuite) TestLabelDrop(c *C) {
+	c.Assert(relabelMetrics(c, `
+metric_relabel_configs:
+  - regex: "server|mode"
+    action: labeldrop
+`), DeepEquals, []string{
+		"pg_locks_count{datname=app}",
+		"pg_locks_count{datname=postgres}",
+		"pg_up{}",
+	})
+}
+
+func (s *RelabelSuite) TestReadRelabelConfigs(c *C) {
+	configs, err := readRelabelConfigs("")
+	c.Assert(err, IsNil)
+	c.Assert(configs, HasLen, 0)
+
+	path := filepath.Join(c.MkDir(), "relabel.yaml")
+	for content, expected := range map[string]string{
+		"metric_relabel_configs:\n  - source_labels: [datname]\n    action: keep\n":                 "",
+		"metric_relabel_configs:\n  - action: keep\n":                                               "source_labels are required for the keep action",
+		"metric_relabel_configs:\n  - action: labelkeep\n    regex: server\n":                       `unknown relabel action "labelkeep"`,
+		"metric_relabel_configs:\n  - source_labels: [mode]\n    target_label: 1mode\n":             `invalid target_label "1mode" for the replace action`,
+		"metric_relabel_configs:\n  - source_labels: [mode]\n    regex: \"(\"\n":                    `invalid regex "\(": .*`,
+		"metric_relabel_configs:\n  - regex: mode\n    target_label: mode\n    action: labeldrop\n": "source_labels and target_label are not allowed for the labeldrop action",
+		"relabel_configs: []\n": "(?s).*field relabel_configs not found.*",
+	} {
+		c.Assert(ioutil.WriteFile(path, []byte(content), 0600), IsNil)
+		_, err := readRelabelConfigs(path)
+		if expected == "" {
+			c.Check(err, IsNil, Commentf("%s", content))
+			continue
+		}
+		c.Check(err, ErrorMatches, expected, Commentf("%s", content))
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/exporter-toolkit/web"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	sslCertFile = kingpin.Flag("web.ssl-cert-file", "Path to SSL certificate file.").String()
+	sslKeyFile  = kingpin.Flag("web.ssl-key-file", "Path to SSL key file.").String()
+	authFile    = kingpin.Flag("web.auth-file", "Path to YAML file with server_user, server_password keys for HTTP Basic authentication "+
+		"(overrides HTTP_AUTH environment variable).").String()
+	webConfigFile = kingpin.Flag("web.config.file", "Path to a web configuration file with the TLS certificates and the bcrypt-hashed HTTP Basic authentication users "+
+		"(can't be used with --web.ssl-cert-file, --web.ssl-key-file, --web.auth-file or HTTP_AUTH).").Default("").Envar("PG_EXPORTER_WEB_CONFIG_FILE").String()
+	enableLogLevel    = kingpin.Flag("web.enable-log-level", "Enable the /-/log-level endpoint, which changes the log level with a PUT request.").Default("false").Envar("PG_EXPORTER_WEB_ENABLE_LOG_LEVEL").Bool()
+	enableOpenMetrics = kingpin.Flag("web.enable-openmetrics", "Serve /metrics and /probe in the OpenMetrics format when Prometheus asks for it, with the exemplars of the custom query durations.").Default("false").Envar("PG_EXPORTER_WEB_ENABLE_OPENMETRICS").Bool()
+	shutdownTimeout   = kingpin.Flag("web.shutdown-timeout", "Maximum time to wait for the in-flight requests to finish on SIGINT or SIGTERM.").Default("30s").Envar("PG_EXPORTER_WEB_SHUTDOWN_TIMEOUT").Duration()
+
+	landingPage = template.Must(template.New("home").Parse(strings.TrimSpace(`
+<html>
+<head>
+	<title>{{ .name }} exporter</title>
+</head>
+<body>
+	<h1>{{ .name }} exporter</h1>
+	<p>Version: {{ .version }}</p>
+	<p><a href="{{ .path }}">Metrics</a></p>
+</body>
+</html>
+`)))
+)
+
+// runServer serves the given handlers, keyed by path, behind HTTP basic
+// authentication (if configured), and a landing page linking to the metrics
+// path at /. It serves HTTPS if a certificate and key are configured. If
+// --web.config.file is set, the TLS and basic authentication it configures
+// are applied to the whole server instead.
+// Function returns once the server was shut down on SIGINT or SIGTERM.
+func runServer(name, addr, metricsPath string, handlers map[string]http.Handler) {
+	ssl, err := webTLSEnabled()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkWebConfigFile(); err != nil {
+		log.Fatal(err)
+	}
+
+	auth := readBasicAuth()
+	if auth.Username != "" && auth.Password != "" {
+		log.Infoln("HTTP Basic authentication is enabled.")
+	}
+
+	tracker := &requestTracker{}
+	mux, err := newServeMux(name, metricsPath, handlers, auth, tracker, ssl)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if *webConfigFile != "" {
+			log.Infof("Starting server for %s%s with web configuration file %s ...", addr, metricsPath, *webConfigFile)
+			serveErr <- web.ListenAndServe(srv, *webConfigFile, kitLogger{log.Base()})
+			return
+		}
+		if ssl {
+			srv.TLSConfig = tlsConfig()
+			log.Infof("Starting HTTPS server for https://%s%s ...", addr, metricsPath)
+			serveErr <- srv.ListenAndServeTLS(*sslCertFile, *sslKeyFile)
+			return
+		}
+		log.Infof("Starting HTTP server for http://%s%s ...", addr, metricsPath)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case sig := <-signals:
+		log.Infof("Received %s, shutting down ...", sig)
+		drained := shutdownServer(srv, tracker, *shutdownTimeout)
+		log.Infof("Shutdown complete, %d in-flight requests drained.", drained)
+	}
+}
+
+// newServeMux returns the mux of runServer: the handlers behind HTTP basic
+// authentication, tracked for the shutdown, and the landing page at /.
+func newServeMux(name, metricsPath string, handlers map[string]http.Handler, auth basicAuth, tracker *requestTracker, ssl bool) (*http.ServeMux, error) {
+	var landing bytes.Buffer
+	data := map[string]string{"name": name, "path": metricsPath, "version": version.Info()}
+	if err := landingPage.Execute(&landing, data); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	for path, handler := range handlers {
+		mux.Handle(path, tracker.wrap(authHandler(auth, handler)))
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if ssl {
+			w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+		w.Write(landing.Bytes()) // nolint: errcheck
+	})
+	return mux, nil
+}
+
+// checkWebConfigFile validates --web.config.file, and returns an error if
+// it is used together with the other TLS and basic authentication settings.
+func checkWebConfigFile() error {
+	if *webConfigFile == "" {
+		return nil
+	}
+	if *sslCertFile != "" || *sslKeyFile != "" || *authFile != "" || os.Getenv("HTTP_AUTH") != "" {
+		return errors.New("--web.config.file can't be used with --web.ssl-cert-file, --web.ssl-key-file, --web.auth-file or HTTP_AUTH")
+	}
+	if err := web.Validate(*webConfigFile); err != nil {
+		return fmt.Errorf("invalid --web.config.file %q: %s", *webConfigFile, err)
+	}
+	return nil
+}
+
+// kitLogger adapts log.Logger to the go-kit logger of the exporter toolkit.
+type kitLogger struct {
+	logger log.Logger
+}
+
+// Log implements github.com/go-kit/kit/log.Logger.
+func (l kitLogger) Log(keyvals ...interface{}) error {
+	var (
+		lvl   interface{}
+		pairs []string
+	)
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		switch keyvals[i] {
+		case level.Key():
+			lvl = value
+		case "msg":
+			pairs = append([]string{fmt.Sprint(value)}, pairs...)
+		default:
+			pairs = append(pairs, fmt.Sprintf("%v=%v", keyvals[i], value))
+		}
+	}
+
+	msg := strings.Join(pairs, " ")
+	switch lvl {
+	case level.DebugValue():
+		l.logger.Debugln(msg)
+	case level.WarnValue():
+		l.logger.Warnln(msg)
+	case level.ErrorValue():
+		l.logger.Errorln(msg)
+	default:
+		l.logger.Infoln(msg)
+	}
+	return nil
+}
+
+// logLevelHandler changes the level of the logger on PUT requests, to the
+// level in the body, e.g. "debug".
+type logLevelHandler struct {
+	logger log.Logger
+}
+
+func newLogLevelHandler(logger log.Logger) *logLevelHandler {
+	return &logLevelHandler{logger: logger}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *logLevelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.Header().Set("Allow", http.MethodPut)
+		http.Error(w, "Only PUT requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 64))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read the log level: %s", err), http.StatusBadRequest)
+		return
+	}
+	level := strings.TrimSpace(string(body))
+	if err := h.logger.SetLevel(level); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid log level %q: %s", level, err), http.StatusBadRequest)
+		return
+	}
+	h.logger.Infof("Log level set to %s.", level)
+	fmt.Fprintf(w, "Log level set to %s.\n", level)
+}
+
+// requestTracker counts the requests being served, and those which finished
+// once the shutdown started.
+type requestTracker struct {
+	inFlight     int64
+	drained      int64
+	shuttingDown int32
+}
+
+// wrap tracks the requests of the handler.
+func (t *requestTracker) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&t.inFlight, 1)
+		defer func() {
+			atomic.AddInt64(&t.inFlight, -1)
+			if atomic.LoadInt32(&t.shuttingDown) == 1 {
+				atomic.AddInt64(&t.drained, 1)
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shutdownServer stops accepting new connections, and waits up to timeout
+// for the in-flight requests to finish. It returns the number of requests
+// which finished in the meantime.
+func shutdownServer(srv *http.Server, tracker *requestTracker, timeout time.Duration) int64 {
+	atomic.StoreInt32(&tracker.shuttingDown, 1)
+	log.Infof("Waiting up to %s for %d in-flight requests ...", timeout, atomic.LoadInt64(&tracker.inFlight))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warnf("Gave up waiting for %d in-flight requests: %v", atomic.LoadInt64(&tracker.inFlight), err)
+	}
+	return atomic.LoadInt64(&tracker.drained)
+}
+
+// tlsConfig returns a new tls.Config instance configured according to Percona's security baseline.
+func tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: true,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+	}
+}
+
+// basicAuth combines username and password.
+type basicAuth struct {
+	Username string `yaml:"server_user,omitempty"`
+	Password string `yaml:"server_password,omitempty"`
+}
+
+// webTLSEnabled returns whether HTTPS is configured, and an error if only one
+// of the certificate and key files is set or if one of them doesn't exist.
+func webTLSEnabled() (bool, error) {
+	if (*sslCertFile == "") != (*sslKeyFile == "") {
+		return false, errors.New("one of the flags --web.ssl-cert-file or --web.ssl-key-file is missing to enable HTTPS")
+	}
+	if *sslCertFile == "" {
+		return false, nil
+	}
+	if _, err := os.Stat(*sslCertFile); os.IsNotExist(err) {
+		return false, fmt.Errorf("SSL certificate file does not exist: %s", *sslCertFile)
+	}
+	if _, err := os.Stat(*sslKeyFile); os.IsNotExist(err) {
+		return false, fmt.Errorf("SSL key file does not exist: %s", *sslKeyFile)
+	}
+	return true, nil
+}
+
+// readBasicAuth returns basicAuth from --web.auth-file file, or HTTP_AUTH environment variable, or empty one.
+// It exits if they can't be read.
+func readBasicAuth() basicAuth {
+	auth, err := loadBasicAuth()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return auth
+}
+
+// loadBasicAuth is readBasicAuth returning the errors.
+func loadBasicAuth() (basicAuth, error) {
+	var auth basicAuth
+	httpAuth := os.Getenv("HTTP_AUTH")
+	switch {
+	case *authFile != "":
+		bytes, err := ioutil.ReadFile(*authFile)
+		if err != nil {
+			return auth, fmt.Errorf("cannot read auth file %q: %s", *authFile, err)
+		}
+		if err = yaml.Unmarshal(bytes, &auth); err != nil {
+			return auth, fmt.Errorf("cannot parse auth file %q: %s", *authFile, err)
+		}
+	case httpAuth != "":
+		data := strings.SplitN(httpAuth, ":", 2)
+		if len(data) != 2 || data[0] == "" || data[1] == "" {
+			return auth, errors.New("HTTP_AUTH should be formatted as user:password")
+		}
+		auth.Username = data[0]
+		auth.Password = data[1]
+	}
+	return auth, nil
+}
+
+// authHandler wraps the handler with basic authentication if it is configured.
+func authHandler(auth basicAuth, next http.Handler) http.Handler {
+	if auth.Username == "" || auth.Password == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		usernameOk := subtle.ConstantTimeCompare([]byte(auth.Username), []byte(username)) == 1
+		passwordOk := subtle.ConstantTimeCompare([]byte(auth.Password), []byte(password)) == 1
+		if !usernameOk || !passwordOk {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,264 @@
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/exporter-toolkit/web"
+	"golang.org/x/crypto/bcrypt"
+	. "gopkg.in/check.v1"
+)
+
+type WebSuite struct{}
+
+var _ = Suite(&WebSuite{})
+
+func (s *WebSuite) TestAuthHandler(c *C) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	h := authHandler(basicAuth{}, next)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	c.Assert(rec.Code, Equals, http.StatusOK)
+
+	h = authHandler(basicAuth{Username: "user", Password: "secret"}, next)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	c.Assert(rec.Code, Equals, http.StatusUnauthorized)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.SetBasicAuth("user", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, http.StatusOK)
+}
+
+func (s *WebSuite) TestLandingPage(c *C) {
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	version.Version = "1.2.3"
+	defer func() { version.Version = "" }()
+	mux, err := newServeMux("Postgres", "/metrics", map[string]http.Handler{"/metrics": metrics}, basicAuth{}, &requestTracker{}, false)
+	c.Assert(err, IsNil)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/")
+	c.Assert(err, IsNil)
+	defer resp.Body.Close() // nolint: errcheck
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Matches, `(?s).*<a href="/metrics">Metrics</a>.*`)
+	c.Assert(string(body), Matches, `(?s).*Version: \(version=1\.2\.3,.*`)
+}
+
+func (s *WebSuite) TestReloadHandler(c *C) {
+	dir := c.MkDir()
+	hrDir := filepath.Join(dir, "hr")
+	c.Assert(os.Mkdir(hrDir, 0755), IsNil)
+	queries, err := ioutil.ReadFile("./tests/user_queries_ok.yaml")
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hrDir, "queries.yaml"), queries, 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hrDir, "README.txt"), []byte("not a query file"), 0644), IsNil)
+
+	e := NewExporter(nil,
+		WithUserQueriesPath(map[MetricResolution]string{HR: hrDir, MR: "", LR: ""}),
+		WithUserQueriesEnabled(map[MetricResolution]bool{HR: true, MR: true, LR: true}),
+	)
+	h := newReloadHandler(e)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reload", nil))
+	c.Assert(rec.Code, Equals, http.StatusMethodNotAllowed)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("Content-Type"), Equals, "application/json")
+	c.Assert(rec.Body.String(), Equals, `{"hr":{"files":1,"queries":2}}`+"\n")
+
+	c.Assert(ioutil.WriteFile(filepath.Join(hrDir, "broken.yml"), []byte("pg_broken: [unclosed"), 0644), IsNil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	c.Assert(rec.Code, Equals, http.StatusInternalServerError)
+	c.Assert(rec.Body.String(), Matches, "Failed to reload custom queries: failed to parse .*broken.yml: .*\n")
+}
+
+func (s *WebSuite) TestLogLevelHandler(c *C) {
+	var buf bytes.Buffer
+	logger := log.NewLogger(&buf)
+	h := newLogLevelHandler(logger)
+
+	logger.Debugln("before")
+	c.Assert(buf.String(), Equals, "")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/log-level", nil))
+	c.Assert(rec.Code, Equals, http.StatusMethodNotAllowed)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/-/log-level", strings.NewReader("verbose")))
+	c.Assert(rec.Code, Equals, http.StatusBadRequest)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/-/log-level", strings.NewReader("debug\n")))
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), Equals, "Log level set to debug.\n")
+
+	logger.Debugln("after")
+	c.Assert(buf.String(), Matches, `(?s).*level=debug msg=after.*`)
+}
+
+func (s *WebSuite) TestReloadDataSourceFile(c *C) {
+	path := filepath.Join(c.MkDir(), "dsn.txt")
+	c.Assert(ioutil.WriteFile(path, []byte(`
+# Primaries
+postgresql://exporter@db1:5432/postgres
+
+  postgresql://exporter@db2:5432/postgres
+`), 0644), IsNil)
+
+	e := NewExporter([]string{"postgresql://exporter@env:5432/postgres"}, WithDataSourceFile(path))
+	c.Assert(e.dataSources(), DeepEquals, []string{"postgresql://exporter@env:5432/postgres"})
+
+	h := newReloadHandler(e)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(e.dataSources(), DeepEquals, []string{
+		"postgresql://exporter@env:5432/postgres",
+		"postgresql://exporter@db1:5432/postgres",
+		"postgresql://exporter@db2:5432/postgres",
+	})
+
+	// The connections to the removed DSNs, and to the databases discovered
+	// on their servers, are closed.
+	servers := make(map[string]sqlmock.Sqlmock)
+	for _, dsn := range []string{
+		"postgresql://exporter@env:5432/postgres",
+		"postgresql://exporter@db1:5432/postgres",
+		"postgresql://exporter@db1:5432/orders",
+		"postgresql://exporter@db2:5432/postgres",
+	} {
+		server, mock := newMockServer(c, "13.0.0")
+		e.servers.servers[dsn] = server
+		servers[dsn] = mock
+	}
+	servers["postgresql://exporter@db1:5432/postgres"].ExpectClose()
+	servers["postgresql://exporter@db1:5432/orders"].ExpectClose()
+
+	c.Assert(ioutil.WriteFile(path, []byte("postgresql://exporter@db2:5432/postgres\n"), 0644), IsNil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(e.dataSources(), HasLen, 2)
+	c.Assert(e.servers.servers, HasLen, 2)
+	c.Assert(e.servers.servers["postgresql://exporter@db2:5432/postgres"], NotNil)
+	for _, mock := range servers {
+		c.Assert(mock.ExpectationsWereMet(), IsNil)
+	}
+
+	// The DSNs are kept if the file can't be read.
+	c.Assert(os.Remove(path), IsNil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	c.Assert(rec.Code, Equals, http.StatusInternalServerError)
+	c.Assert(rec.Body.String(), Matches, "Failed to reload the data source file: .*\n")
+	c.Assert(e.dataSources(), HasLen, 2)
+}
+
+func (s *WebSuite) TestShutdownServer(c *C) {
+	tracker := &requestTracker{}
+	release := make(chan struct{})
+	srv := &http.Server{Handler: tracker.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("ok")) // nolint: errcheck
+	}))}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	go srv.Serve(ln) // nolint: errcheck
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/metrics")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close() // nolint: errcheck
+		status <- resp.StatusCode
+	}()
+	for atomic.LoadInt64(&tracker.inFlight) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The scrape in flight finishes before the server stops.
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	c.Assert(shutdownServer(srv, tracker, 5*time.Second), Equals, int64(1))
+	c.Assert(<-status, Equals, http.StatusOK)
+
+	_, err = http.Get("http://" + ln.Addr().String() + "/metrics")
+	c.Assert(err, NotNil)
+}
+
+func (s *WebSuite) TestWebConfigFile(c *C) {
+	defer func(path string) { *webConfigFile = path }(*webConfigFile)
+	path := filepath.Join(c.MkDir(), "web-config.yml")
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(path, []byte("basic_auth_users:\n  user: "+string(hash)+"\n"), 0600), IsNil)
+
+	*webConfigFile = path
+	c.Assert(checkWebConfigFile(), IsNil)
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok")) // nolint: errcheck
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer srv.Close() // nolint: errcheck
+
+	go web.Serve(ln, srv, path, kitLogger{log.Base()}) // nolint: errcheck
+	resp, err := http.Get("http://" + ln.Addr().String() + "/metrics")
+	c.Assert(err, IsNil)
+	resp.Body.Close() // nolint: errcheck
+	c.Assert(resp.StatusCode, Equals, http.StatusUnauthorized)
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+ln.Addr().String()+"/metrics", nil)
+	c.Assert(err, IsNil)
+	req.SetBasicAuth("user", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close() // nolint: errcheck
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+
+	// Users with a plain text password, and a missing certificate.
+	c.Assert(ioutil.WriteFile(path, []byte("basic_auth_users:\n  user: secret\n"), 0600), IsNil)
+	c.Assert(checkWebConfigFile(), ErrorMatches, `invalid --web.config.file ".*": .*`)
+	c.Assert(ioutil.WriteFile(path, []byte("tls_server_config:\n  cert_file: missing.crt\n  key_file: missing.key\n"), 0600), IsNil)
+	c.Assert(checkWebConfigFile(), ErrorMatches, `invalid --web.config.file ".*": .*`)
+
+	defer func(file string) { *authFile = file }(*authFile)
+	*authFile = "auth.yaml"
+	c.Assert(checkWebConfigFile(), ErrorMatches, "--web.config.file can't be used with .*")
+}
@@ -3,17 +3,21 @@ module github.com/prometheus-community/postgres_exporter
 go 1.14
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/alecthomas/units v0.0.0-20210208195552-ff826a37aa15 // indirect
 	github.com/blang/semver v3.5.1+incompatible
+	github.com/go-kit/kit v0.10.0
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/go-querystring v1.1.0 // indirect
+	github.com/jackc/pgx/v4 v4.11.0
 	github.com/lib/pq v1.9.0
-	github.com/percona/exporter_shared v0.7.3
 	github.com/percona/postgres_exporter v0.4.6 // indirect
 	github.com/prometheus/client_golang v1.10.0
 	github.com/prometheus/client_model v0.2.0
 	github.com/prometheus/common v0.23.0
+	github.com/prometheus/exporter-toolkit v0.5.1
 	github.com/prometheus/promu v0.12.0 // indirect
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 	golang.org/x/net v0.0.0-20210428140749-89ef3d95e781 // indirect
 	golang.org/x/oauth2 v0.0.0-20210427180440-81ed05c6b58c // indirect
 	golang.org/x/sys v0.0.0-20210426230700-d19ff857e887 // indirect